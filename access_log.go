@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+//
+// ===================== ACCESS LOG CLIENTS =====================
+//
+
+// AccessLogExtraction describes the client behind access-log lines, so an
+// error spike can be traced to one network or one client version. Lines
+// in common/combined log format, and JSON or logfmt lines with a client IP
+// or user agent field, are enriched; others are left alone.
+type AccessLogExtraction struct {
+	// CountryDB and ASNDB are MaxMind databases such as
+	// GeoLite2-Country.mmdb (or -City) and GeoLite2-ASN.mmdb. Updated files
+	// are picked up within a minute.
+	CountryDB string `yaml:"country_db,omitempty"`
+	ASNDB     string `yaml:"asn_db,omitempty"`
+	// UserAgent parses the user agent into a family and version.
+	UserAgent bool `yaml:"user_agent,omitempty"`
+}
+
+// ClientInfo is what AccessLogExtraction found about a line's client.
+type ClientInfo struct {
+	IP               string `json:"ip,omitempty"`
+	Country          string `json:"country,omitempty"`
+	ASN              uint64 `json:"asn,omitempty"`
+	ASOrg            string `json:"as_org,omitempty"`
+	UserAgent        string `json:"user_agent,omitempty"`
+	UserAgentVersion string `json:"user_agent_version,omitempty"`
+}
+
+// combinedLogRegex matches common log format, with the combined format's
+// referer and user agent when present.
+var combinedLogRegex = regexp.MustCompile(`^(\S+) \S+ \S+ \[[^\]]+\] "[^"]*" \d{3} \S+(?: "[^"]*" "([^"]*)")?`)
+
+var (
+	clientIPFields  = []string{"client_ip", "remote_addr", "remote_ip", "clientip", "ip"}
+	userAgentFields = []string{"user_agent", "http_user_agent", "userAgent", "ua"}
+)
+
+func (cfg *Config) accessLogExtraction() *AccessLogExtraction {
+	if cfg == nil || cfg.Extractors == nil {
+		return nil
+	}
+	return cfg.Extractors.AccessLog
+}
+
+// applyTo sets Client on a formatted line that carries a client IP or user
+// agent.
+func (a *AccessLogExtraction) applyTo(out *LogOutput) {
+	ip, ua := accessLogClient(out.Raw)
+	if ip == "" && ua == "" {
+		return
+	}
+
+	c := &ClientInfo{IP: ip}
+	if addr := net.ParseIP(ip); addr != nil {
+		if a.CountryDB != "" {
+			if db, _ := loadMMDB(a.CountryDB); db != nil {
+				if rec, err := db.lookup(addr); err == nil {
+					c.Country, _ = fieldString(rec, "country.iso_code", nil)
+				}
+			}
+		}
+		if a.ASNDB != "" {
+			if db, _ := loadMMDB(a.ASNDB); db != nil {
+				if rec, err := db.lookup(addr); err == nil {
+					if v, ok := lookupPath(rec, "autonomous_system_number"); ok {
+						c.ASN, _ = v.(uint64)
+					}
+					c.ASOrg, _ = fieldString(rec, "autonomous_system_organization", nil)
+				}
+			}
+		}
+	}
+	if a.UserAgent && ua != "" {
+		c.UserAgent, c.UserAgentVersion = parseUserAgent(ua)
+	}
+	if *c != (ClientInfo{}) {
+		out.Client = c
+	}
+}
+
+// accessLogClient returns the client IP and user agent of an access-log
+// line.
+func accessLogClient(line string) (ip, ua string) {
+	if m := combinedLogRegex.FindStringSubmatch(line); m != nil {
+		if net.ParseIP(m[1]) != nil {
+			ip = m[1]
+		}
+		if m[2] != "-" {
+			ua = m[2]
+		}
+		return ip, ua
+	}
+
+	_, record := classifyLine(line)
+	if record == nil {
+		return "", ""
+	}
+	if v, ok := fieldString(record, "", clientIPFields); ok {
+		// X-Forwarded-For style lists start with the original client.
+		v = strings.TrimSpace(strings.Split(v, ",")[0])
+		if host, _, err := net.SplitHostPort(v); err == nil {
+			v = host
+		}
+		if net.ParseIP(v) != nil {
+			ip = v
+		}
+	}
+	ua, _ = fieldString(record, "", userAgentFields)
+	return ip, ua
+}
+
+// userAgentFamilies are tried in order, so browsers whose user agents also
+// claim to be Chrome or Safari come before them.
+var userAgentFamilies = []struct {
+	family  string
+	token   string
+	version string // token holding the version, when not token itself
+}{
+	{"Edge", "Edg/", ""},
+	{"Edge", "Edge/", ""},
+	{"Opera", "OPR/", ""},
+	{"Samsung Internet", "SamsungBrowser/", ""},
+	{"Firefox", "Firefox/", ""},
+	{"Firefox", "FxiOS/", ""},
+	{"Chrome", "CriOS/", ""},
+	{"Chrome", "Chrome/", ""},
+	{"Safari", "Safari/", "Version/"},
+	{"Internet Explorer", "MSIE ", ""},
+	{"Internet Explorer", "Trident/", "rv:"},
+}
+
+var (
+	uaProductRegex = regexp.MustCompile(`^([A-Za-z][\w.-]*)/([\w.]+)`)
+	// uaCompatibleRegex finds crawlers such as "(compatible; Googlebot/2.1; ...)".
+	uaCompatibleRegex = regexp.MustCompile(`compatible; ([A-Za-z][\w.-]*)/([\w.]+)`)
+)
+
+// parseUserAgent returns a user agent's family and version. Crawlers and
+// browsers are recognized by their tokens; anything else (curl, SDKs,
+// mobile apps) is named by its first product token, as in
+// "MyApp/3.2.1 (iOS 17)".
+func parseUserAgent(ua string) (family, version string) {
+	if strings.HasPrefix(ua, "Mozilla/") {
+		if m := uaCompatibleRegex.FindStringSubmatch(ua); m != nil {
+			return m[1], m[2]
+		}
+		for _, f := range userAgentFamilies {
+			if !strings.Contains(ua, f.token) {
+				continue
+			}
+			token := f.token
+			if f.version != "" {
+				token = f.version
+			}
+			if i := strings.Index(ua, token); i >= 0 {
+				version = ua[i+len(token):]
+				version = version[:strings.IndexFunc(version+" ", func(r rune) bool {
+					return r == ' ' || r == ';' || r == ')'
+				})]
+			}
+			return f.family, version
+		}
+	}
+	if m := uaProductRegex.FindStringSubmatch(ua); m != nil {
+		return m[1], m[2]
+	}
+	return "Other", ""
+}
+
+func validateAccessLogExtraction(a *AccessLogExtraction) []string {
+	var problems []string
+	for _, db := range []struct{ field, path string }{
+		{"extractors.access_log.country_db", a.CountryDB},
+		{"extractors.access_log.asn_db", a.ASNDB},
+	} {
+		if db.path == "" {
+			continue
+		}
+		if _, err := loadMMDB(db.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", db.field, err))
+		}
+	}
+	return problems
+}
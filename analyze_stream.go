@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// ===================== /logs/analyze/stream =====================
+//
+
+const (
+	defaultAnalyzeWindow = 1000
+	maxAnalyzeWindow     = 100000
+)
+
+// AnalyzeEvent is one item of the /logs/analyze/stream output. Type is
+// window (per-window counts), finding, error or done.
+type AnalyzeEvent struct {
+	Type   string `json:"type"`
+	Window int    `json:"window,omitempty"`
+	// FirstLine and LastLine number the non-blank input lines the event
+	// covers, starting at 1.
+	FirstLine int `json:"first_line,omitempty"`
+	LastLine  int `json:"last_line,omitempty"`
+
+	// Analyzer names the analyzer behind a finding or error.
+	Analyzer string `json:"analyzer,omitempty"`
+
+	Lines        int                 `json:"lines,omitempty"`
+	Severities   map[string]int      `json:"severities,omitempty"`
+	ErrorClasses ErrorClassCounts    `json:"error_classes,omitempty"`
+	HTTPStatus   *HTTPStatusCounts   `json:"http_status,omitempty"`
+	LatencyMS    *LatencyPercentiles `json:"latency_ms,omitempty"`
+	Title        string              `json:"title,omitempty"`
+	Description  string              `json:"description,omitempty"`
+	Severity     string              `json:"severity,omitempty"`
+	Message      string              `json:"message,omitempty"`
+
+	Windows  int `json:"windows,omitempty"`
+	Findings int `json:"findings,omitempty"`
+}
+
+// windowEvent is the window event for win.
+func windowEvent(win *AnalysisWindow) AnalyzeEvent {
+	return AnalyzeEvent{
+		Type:         "window",
+		Window:       win.Window,
+		FirstLine:    win.FirstLine,
+		LastLine:     win.LastLine,
+		Lines:        len(win.Lines),
+		Severities:   win.Severities,
+		ErrorClasses: win.ErrorClasses,
+		HTTPStatus:   win.HTTPStatus,
+		LatencyMS:    win.LatencyMS,
+	}
+}
+
+// analyzeInputLine returns the log line an NDJSON input line stands for: the
+// raw field of a /logs output line, a JSON string, or the line itself (a
+// JSON log record or plain text).
+func analyzeInputLine(line string) string {
+	var v interface{}
+	if json.Unmarshal([]byte(line), &v) != nil {
+		return line
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if raw, ok := v["raw"].(string); ok {
+			return raw
+		}
+	}
+	return line
+}
+
+// logsAnalyzeStreamHandler reads NDJSON log lines of any length, analyzes
+// them window= lines at a time and streams events back as they are found:
+// NDJSON by default, server-sent events with format=sse or an Accept of
+// text/event-stream, or concatenated MessagePack objects with
+// format=msgpack or an Accept preferring it.
+func logsAnalyzeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	size := defaultAnalyzeWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAnalyzeWindow {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("window must be between 1 and %d", maxAnalyzeWindow))
+			return
+		}
+		size = n
+	}
+	format := r.URL.Query().Get("format")
+	switch {
+	case format != "":
+	case strings.Contains(r.Header.Get("Accept"), "text/event-stream"):
+		format = "sse"
+	case wantsMsgpack(r):
+		format = "msgpack"
+	default:
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "sse" && format != "msgpack" {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown format %q (expected ndjson, sse or msgpack)", format))
+		return
+	}
+
+	cfg := getConfig()
+	maxLine := cfg.readSettings("", "").MaxLineBytes
+
+	// The input may take far longer to arrive than the server's read and
+	// write timeouts allow for ordinary requests, and HTTP/1 would
+	// otherwise stop reading it once events are written.
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// Reading before the response starts sends any 100 Continue the client
+	// waits for; after it the server would refuse to read the body at all.
+	br := bufio.NewReader(r.Body)
+	br.Peek(1)
+
+	switch format {
+	case "sse":
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	case "msgpack":
+		w.Header().Set("Content-Type", msgpackContentType)
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	emit := func(e AnalyzeEvent) {
+		switch format {
+		case "sse":
+			fmt.Fprintf(w, "event: %s\ndata: ", e.Type)
+			enc.Encode(e)
+			io.WriteString(w, "\n")
+		case "msgpack":
+			if b, err := marshalMsgpack(e); err == nil {
+				w.Write(b)
+			}
+		default:
+			enc.Encode(e)
+		}
+	}
+
+	analyzers := newAnalyzers(cfg)
+	defer func() { closeAnalyzers(analyzers) }()
+
+	var (
+		lines    []LogOutput
+		first    = 1
+		total    int
+		windows  int
+		findings int
+	)
+	flush := func() {
+		if len(lines) == 0 {
+			return
+		}
+		windows++
+		win := newAnalysisWindow(windows, first, lines)
+		emit(windowEvent(win))
+		active := analyzers[:0]
+		for _, a := range analyzers {
+			found, err := a.Analyze(r.Context(), win)
+			if err != nil {
+				// A failing analyzer is dropped rather than failing every
+				// window after it.
+				emit(AnalyzeEvent{Type: "error", Window: win.Window, Analyzer: a.name, Message: err.Error()})
+				closeAnalyzers([]namedAnalyzer{a})
+				continue
+			}
+			for _, f := range found {
+				findings++
+				emit(AnalyzeEvent{
+					Type:        "finding",
+					Window:      win.Window,
+					FirstLine:   win.FirstLine,
+					LastLine:    win.LastLine,
+					Analyzer:    a.name,
+					Title:       f.Title,
+					Description: f.Description,
+					Severity:    f.Severity,
+				})
+			}
+			active = append(active, a)
+		}
+		analyzers = active
+		rc.Flush()
+		first += len(lines)
+		lines = nil
+	}
+
+	for {
+		line, err := readInputLine(br, maxLine)
+		if line = strings.TrimSpace(line); line != "" {
+			total++
+			lines = append(lines, cfg.extractLine(analyzeInputLine(line)))
+			if len(lines) == size {
+				flush()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			flush()
+			emit(AnalyzeEvent{Type: "error", Message: "reading input: " + err.Error()})
+			return
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	flush()
+	emit(AnalyzeEvent{Type: "done", Lines: total, Windows: windows, Findings: findings})
+}
+
+// readInputLine reads one line, keeping at most maxLine bytes of it (0
+// means unlimited) and marking longer ones as truncated.
+func readInputLine(br *bufio.Reader, maxLine int) (string, error) {
+	var (
+		buf  []byte
+		size int64
+	)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		size += int64(len(chunk))
+		if maxLine <= 0 || len(buf) < maxLine {
+			buf = append(buf, chunk...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		line := strings.TrimRight(string(buf), "\r\n")
+		if n := len(chunk); n > 0 && chunk[n-1] == '\n' {
+			size--
+		}
+		if maxLine > 0 && len(line) > maxLine {
+			line = truncateMarked(line[:maxLine], size)
+		}
+		return line, err
+	}
+}
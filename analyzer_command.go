@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+//
+// ===================== COMMAND ANALYZERS =====================
+//
+
+// AnalyzerConfig runs an external program alongside the built-in
+// analyzers, so a team can look for its own signatures without rebuilding
+// the agent. The program is started once per analysis and speaks JSON
+// lines: for each window it reads an AnalysisWindow on stdin and writes
+// back {"findings": [{"title", "description", "severity"}]}, or
+// {"error": "..."}. Its stderr goes to the agent's. It should exit when
+// stdin is closed.
+type AnalyzerConfig struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// TimeoutSeconds bounds the answer to one window (default 10).
+	TimeoutSeconds int `yaml:"timeout_seconds,omitempty"`
+}
+
+func (c AnalyzerConfig) timeout() time.Duration {
+	if c.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// commandAnalyzer is a running AnalyzerConfig program. It starts on the
+// first window.
+type commandAnalyzer struct {
+	config AnalyzerConfig
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	// replies carries stdout lines; it is closed when stdout ends.
+	replies chan []byte
+	done    chan error
+}
+
+type commandAnalyzerReply struct {
+	Findings []Finding `json:"findings"`
+	Error    string    `json:"error"`
+}
+
+func newCommandAnalyzer(c AnalyzerConfig) *commandAnalyzer {
+	return &commandAnalyzer{config: c}
+}
+
+func (a *commandAnalyzer) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, a.config.Command[0], a.config.Command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return err
+	}
+
+	a.cmd, a.cancel, a.stdin = cmd, cancel, stdin
+	a.replies, a.done = make(chan []byte), make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 64<<10), 16<<20)
+		for sc.Scan() {
+			a.replies <- append([]byte(nil), sc.Bytes()...)
+		}
+		close(a.replies)
+		a.done <- cmd.Wait()
+	}()
+	return nil
+}
+
+func (a *commandAnalyzer) Analyze(ctx context.Context, win *AnalysisWindow) ([]Finding, error) {
+	if a.cmd == nil {
+		if err := a.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := json.Marshal(win)
+	if err != nil {
+		return nil, err
+	}
+	// Written aside so a program that stops reading can't block past the
+	// timeout; Close unblocks the write.
+	written := make(chan error, 1)
+	go func() {
+		_, err := a.stdin.Write(append(req, '\n'))
+		written <- err
+	}()
+
+	timer := time.NewTimer(a.config.timeout())
+	defer timer.Stop()
+	select {
+	case err := <-written:
+		if err != nil {
+			return nil, fmt.Errorf("writing window: %w", err)
+		}
+	case <-timer.C:
+		return nil, fmt.Errorf("window not read within %s", a.config.timeout())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case line, ok := <-a.replies:
+		if !ok {
+			return nil, errors.New("exited without answering")
+		}
+		var reply commandAnalyzerReply
+		if err := json.Unmarshal(line, &reply); err != nil {
+			return nil, fmt.Errorf("invalid reply: %v", err)
+		}
+		if reply.Error != "" {
+			return nil, errors.New(reply.Error)
+		}
+		return reply.Findings, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no answer within %s", a.config.timeout())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close ends the program, killing it if it does not exit within a second
+// of stdin closing.
+func (a *commandAnalyzer) Close() error {
+	if a.cmd == nil {
+		return nil
+	}
+	defer a.cancel()
+	a.stdin.Close()
+	go func() {
+		// Drop anything written after the last answer.
+		for range a.replies {
+		}
+	}()
+	select {
+	case err := <-a.done:
+		return err
+	case <-time.After(time.Second):
+		a.cancel()
+		<-a.done
+		return errors.New("killed after stdin closed")
+	}
+}
+
+var analyzerNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+func validateAnalyzers(analyzers []AnalyzerConfig) []string {
+	seen := map[string]bool{}
+	for _, f := range registeredAnalyzers {
+		seen[f.name] = true
+	}
+
+	var problems []string
+	for i, c := range analyzers {
+		field := fmt.Sprintf("analyzers[%d]", i)
+		switch {
+		case !analyzerNameRegex.MatchString(c.Name):
+			problems = append(problems, fmt.Sprintf("%s.name: invalid name %q (lower-case letters, digits, _ and -)", field, c.Name))
+		case seen[c.Name]:
+			problems = append(problems, fmt.Sprintf("%s.name: %q is already used", field, c.Name))
+		}
+		seen[c.Name] = true
+		if len(c.Command) == 0 || c.Command[0] == "" {
+			problems = append(problems, field+".command: required")
+		} else if _, err := exec.LookPath(c.Command[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.command: %v", field, err))
+		}
+		if c.TimeoutSeconds < 0 {
+			problems = append(problems, field+".timeout_seconds: must not be negative")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+//
+// ===================== ANALYZERS =====================
+//
+
+// Finding is one thing an analyzer noticed in a window of log lines.
+type Finding struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Severity is LOW, MEDIUM or HIGH.
+	Severity string `json:"severity"`
+}
+
+// AnalysisWindow is a run of consecutive input lines with their counts,
+// computed once for every analyzer. It is also what command analyzers
+// receive, one JSON line per window.
+type AnalysisWindow struct {
+	Window int `json:"window"`
+	// FirstLine and LastLine number the non-blank input lines the window
+	// covers, starting at 1.
+	FirstLine    int                 `json:"first_line"`
+	LastLine     int                 `json:"last_line"`
+	Lines        []LogOutput         `json:"lines"`
+	Severities   map[string]int      `json:"severities"`
+	ErrorClasses ErrorClassCounts    `json:"error_classes,omitempty"`
+	HTTPStatus   *HTTPStatusCounts   `json:"http_status,omitempty"`
+	LatencyMS    *LatencyPercentiles `json:"latency_ms,omitempty"`
+}
+
+func newAnalysisWindow(n, first int, lines []LogOutput) *AnalysisWindow {
+	win := &AnalysisWindow{
+		Window:       n,
+		FirstLine:    first,
+		LastLine:     first + len(lines) - 1,
+		Lines:        lines,
+		Severities:   map[string]int{},
+		ErrorClasses: errorClassCounts(lines),
+		HTTPStatus:   httpStatusCounts(lines),
+		LatencyMS:    latencyPercentiles(lines),
+	}
+	for _, l := range lines {
+		if l.Severity != "" {
+			win.Severities[l.Severity]++
+		}
+	}
+	return win
+}
+
+func (win *AnalysisWindow) lineRange() string {
+	return fmt.Sprintf("lines %d-%d", win.FirstLine, win.LastLine)
+}
+
+// Analyzer looks at one window at a time. A new Analyzer is made for each
+// analysis, so it may keep state across the windows of one input. One that
+// also implements io.Closer is closed when the analysis ends.
+type Analyzer interface {
+	Analyze(ctx context.Context, win *AnalysisWindow) ([]Finding, error)
+}
+
+type analyzerFactory struct {
+	name   string
+	create func() Analyzer
+}
+
+// registeredAnalyzers run on every analysis, in registration order.
+var registeredAnalyzers []analyzerFactory
+
+// registerAnalyzer adds a built-in analyzer. Call it from an init function
+// in the file defining the analyzer.
+func registerAnalyzer(name string, create func() Analyzer) {
+	registeredAnalyzers = append(registeredAnalyzers, analyzerFactory{name, create})
+}
+
+// namedAnalyzer is an analyzer as one analysis runs it.
+type namedAnalyzer struct {
+	name string
+	Analyzer
+}
+
+// newAnalyzers makes the registered analyzers followed by those configured
+// under analyzers.
+func newAnalyzers(cfg *Config) []namedAnalyzer {
+	var out []namedAnalyzer
+	for _, f := range registeredAnalyzers {
+		out = append(out, namedAnalyzer{f.name, f.create()})
+	}
+	if cfg != nil {
+		for _, c := range cfg.Analyzers {
+			out = append(out, namedAnalyzer{c.Name, newCommandAnalyzer(c)})
+		}
+	}
+	return out
+}
+
+func closeAnalyzers(analyzers []namedAnalyzer) {
+	for _, a := range analyzers {
+		if c, ok := a.Analyzer.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Warn("analyzer did not exit cleanly", "analyzer", a.name, "error", err)
+			}
+		}
+	}
+}
+
+func init() {
+	registerAnalyzer("error-rate", func() Analyzer { return analyzerFunc(errorRateFindings) })
+	registerAnalyzer("error-class", func() Analyzer { return analyzerFunc(errorClassFindings) })
+	registerAnalyzer("server-errors", func() Analyzer { return analyzerFunc(serverErrorFindings) })
+	registerAnalyzer("latency-spike", func() Analyzer { return &latencySpikeAnalyzer{} })
+	registerAnalyzer("debug-share", func() Analyzer { return analyzerFunc(debugShareFindings) })
+}
+
+// analyzerFunc adapts a stateless check to Analyzer.
+type analyzerFunc func(win *AnalysisWindow) []Finding
+
+func (f analyzerFunc) Analyze(_ context.Context, win *AnalysisWindow) ([]Finding, error) {
+	return f(win), nil
+}
+
+func errorRateFindings(win *AnalysisWindow) []Finding {
+	errs := win.Severities["ERROR"]
+	if errs < 5 {
+		return nil
+	}
+	rate := float64(errs) / float64(len(win.Lines))
+	description := fmt.Sprintf("%d of %d %s are errors (%.0f%%).", errs, len(win.Lines), win.lineRange(), rate*100)
+	switch {
+	case rate >= 0.2:
+		return []Finding{{"High error rate", description, "HIGH"}}
+	case rate >= 0.05:
+		return []Finding{{"Elevated error rate", description, "MEDIUM"}}
+	}
+	return nil
+}
+
+func errorClassFindings(win *AnalysisWindow) []Finding {
+	c := win.ErrorClasses
+	total, top := 0, ""
+	for class, n := range c {
+		total += n
+		if top == "" || n > c[top] || (n == c[top] && class < top) {
+			top = class
+		}
+	}
+	if total < 5 || c[top]*2 <= total {
+		return nil
+	}
+	return []Finding{{
+		fmt.Sprintf("Mostly %s errors", top),
+		fmt.Sprintf("%d of the %d classified errors in %s are %s (%s).", c[top], total, win.lineRange(), top, c),
+		"MEDIUM",
+	}}
+}
+
+func serverErrorFindings(win *AnalysisWindow) []Finding {
+	s := win.HTTPStatus
+	if s == nil {
+		return nil
+	}
+	total := s.Class1xx + s.Class2xx + s.Class3xx + s.Class4xx + s.Class5xx
+	if s.Class5xx < 5 || float64(s.Class5xx) < 0.05*float64(total) {
+		return nil
+	}
+	return []Finding{{"Server errors", fmt.Sprintf("%d of %d responses in %s are 5xx.", s.Class5xx, total, win.lineRange()), "HIGH"}}
+}
+
+// latencySpikeAnalyzer keeps a moving p95 so a spike is judged against
+// earlier windows, not a fixed limit.
+type latencySpikeAnalyzer struct {
+	baselineP95 float64
+}
+
+func (a *latencySpikeAnalyzer) Analyze(_ context.Context, win *AnalysisWindow) ([]Finding, error) {
+	p := win.LatencyMS
+	if p == nil {
+		return nil, nil
+	}
+	var findings []Finding
+	if a.baselineP95 > 0 && p.Count >= 20 && p.P95 > 3*a.baselineP95 {
+		findings = append(findings, Finding{"Latency spike", fmt.Sprintf("p95 is %gms in %s against about %.0fms before.", p.P95, win.lineRange(), a.baselineP95), "MEDIUM"})
+	}
+	if a.baselineP95 == 0 {
+		a.baselineP95 = p.P95
+	} else {
+		a.baselineP95 += 0.2 * (p.P95 - a.baselineP95)
+	}
+	return findings, nil
+}
+
+func debugShareFindings(win *AnalysisWindow) []Finding {
+	debug := win.Severities["DEBUG"]
+	if len(win.Lines) < 100 || debug*2 <= len(win.Lines) {
+		return nil
+	}
+	return []Finding{{"Check DEBUG logs", fmt.Sprintf("%d of %d %s are DEBUG; review them for unnecessary output.", debug, len(win.Lines), win.lineRange()), "LOW"}}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// ===================== OUTBOUND HTTP =====================
+//
+
+// HTTPClientConfig tunes how the agent calls an api log target.
+type HTTPClientConfig struct {
+	TimeoutSeconds int `yaml:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty"`
+	// BearerToken or BasicAuth authenticate the request; set at most one.
+	// Use bearer_token_file / password_file to keep secrets out of the YAML.
+	// The config API accepts secrets and header values but only ever
+	// returns them masked.
+	BearerToken string            `yaml:"bearer_token,omitempty" json:"bearer_token,omitempty"`
+	BasicAuth   *BasicAuthConfig  `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// CAFile adds a PEM bundle to trust for the target's certificate.
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+	// ProxyURL overrides the HTTP(S)_PROXY environment for this target.
+	ProxyURL string       `yaml:"proxy_url,omitempty" json:"proxy_url,omitempty"`
+	Retry    *RetryConfig `yaml:"retry,omitempty" json:"retry,omitempty"`
+}
+
+type BasicAuthConfig struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+}
+
+// secretMask stands in for a secret or header value in config API
+// responses. Sent back unchanged, it keeps the stored value.
+const secretMask = "********"
+
+// masked returns a copy of c with its secrets and header values replaced
+// by secretMask.
+func (c *HTTPClientConfig) masked() *HTTPClientConfig {
+	if c == nil {
+		return nil
+	}
+	out := *c
+	if out.BearerToken != "" {
+		out.BearerToken = secretMask
+	}
+	if c.BasicAuth != nil {
+		basic := *c.BasicAuth
+		if basic.Password != "" {
+			basic.Password = secretMask
+		}
+		out.BasicAuth = &basic
+	}
+	if c.Headers != nil {
+		out.Headers = make(map[string]string, len(c.Headers))
+		for k := range c.Headers {
+			out.Headers[k] = secretMask
+		}
+	}
+	return &out
+}
+
+// keepSecrets fills the secrets and header values that an update left out
+// or sent back masked from old, the config being replaced.
+func (c *HTTPClientConfig) keepSecrets(old *HTTPClientConfig) {
+	if c == nil {
+		return
+	}
+	if old == nil {
+		old = &HTTPClientConfig{}
+	}
+	if c.BearerToken == "" || c.BearerToken == secretMask {
+		c.BearerToken = ""
+		if c.BasicAuth == nil {
+			c.BearerToken = old.BearerToken
+		}
+	}
+	if basic := c.BasicAuth; basic != nil && (basic.Password == "" || basic.Password == secretMask) {
+		basic.Password = ""
+		if old.BasicAuth != nil {
+			basic.Password = old.BasicAuth.Password
+		}
+	}
+	for k, v := range c.Headers {
+		if v != secretMask {
+			continue
+		}
+		if prev, ok := old.Headers[k]; ok {
+			c.Headers[k] = prev
+		} else {
+			delete(c.Headers, k)
+		}
+	}
+}
+
+const defaultAPITimeout = 10 * time.Second
+
+// apiClients caches one client per distinct transport setup so repeated
+// reads of the same target reuse keep-alive connections.
+var apiClients sync.Map // clientKey -> *http.Client
+
+type clientKey struct {
+	timeout  int
+	caFile   string
+	insecure bool
+	proxy    string
+}
+
+func (c *HTTPClientConfig) client() (*http.Client, error) {
+	if c == nil {
+		c = &HTTPClientConfig{}
+	}
+	key := clientKey{c.TimeoutSeconds, c.CAFile, c.InsecureSkipVerify, c.ProxyURL}
+	if cl, ok := apiClients.Load(key); ok {
+		return cl.(*http.Client), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.CAFile != "" || c.InsecureSkipVerify {
+		tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.InsecureSkipVerify}
+		if c.CAFile != "" {
+			pool, err := loadCertPool(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("load ca_file: %w", err)
+			}
+			tlsCfg.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsCfg
+	}
+
+	if c.ProxyURL != "" {
+		proxy, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy_url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	timeout := defaultAPITimeout
+	if c.TimeoutSeconds > 0 {
+		timeout = time.Duration(c.TimeoutSeconds) * time.Second
+	}
+
+	cl, _ := apiClients.LoadOrStore(key, &http.Client{Timeout: timeout, Transport: transport})
+	return cl.(*http.Client), nil
+}
+
+func (c *HTTPClientConfig) retryPolicy() retryPolicy {
+	if c == nil {
+		return (*RetryConfig)(nil).policy()
+	}
+	return c.Retry.policy()
+}
+
+// requestHeaders returns the static headers, including auth, sent with
+// every request to the target.
+func (c *HTTPClientConfig) requestHeaders() http.Header {
+	h := http.Header{}
+	if c == nil {
+		return h
+	}
+	for k, v := range c.Headers {
+		h.Set(k, v)
+	}
+	switch {
+	case c.BearerToken != "":
+		h.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.BasicAuth != nil:
+		creds := c.BasicAuth.Username + ":" + c.BasicAuth.Password
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+	}
+	return h
+}
+
+func validateHTTPClientConfig(field string, c *HTTPClientConfig) []string {
+	var problems []string
+	if c.TimeoutSeconds < 0 {
+		problems = append(problems, field+".timeout_seconds: must not be negative")
+	}
+	if c.BearerToken != "" && c.BasicAuth != nil {
+		problems = append(problems, field+": set only one of bearer_token or basic_auth")
+	}
+	if c.BasicAuth != nil && c.BasicAuth.Username == "" {
+		problems = append(problems, field+".basic_auth.username: required")
+	}
+	if c.CAFile != "" {
+		if _, err := os.Stat(c.CAFile); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.ca_file: %v", field, err))
+		}
+	}
+	if c.ProxyURL != "" {
+		if _, err := url.Parse(c.ProxyURL); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.proxy_url: %v", field, err))
+		}
+	}
+	if c.Retry != nil {
+		problems = append(problems, validateRetryConfig(field, c.Retry)...)
+	}
+	return problems
+}
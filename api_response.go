@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+//
+// ===================== API RESPONSE PARSING =====================
+//
+
+// ResponseConfig tells an api target how to split its response into
+// records. Without it the body is passed through as-is.
+type ResponseConfig struct {
+	// Format is raw (default), json or ndjson.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+	// RecordsPath is the dotted path to the record array in a json body,
+	// e.g. "data.items". Empty means the body itself is the array.
+	RecordsPath string `yaml:"records_path,omitempty" json:"records_path,omitempty"`
+	// Field paths within a record. Each falls back to common names
+	// (message/msg/log, timestamp/time/@timestamp/ts, level/severity).
+	MessageField   string `yaml:"message_field,omitempty" json:"message_field,omitempty"`
+	TimestampField string `yaml:"timestamp_field,omitempty" json:"timestamp_field,omitempty"`
+	LevelField     string `yaml:"level_field,omitempty" json:"level_field,omitempty"`
+	// NewestFirst says records (and pages) come newest first; they are
+	// put back in chronological order.
+	NewestFirst bool              `yaml:"newest_first,omitempty" json:"newest_first,omitempty"`
+	Pagination  *PaginationConfig `yaml:"pagination,omitempty" json:"pagination,omitempty"`
+}
+
+var (
+	defaultMessageFields   = []string{"message", "msg", "log"}
+	defaultTimestampFields = []string{"timestamp", "time", "@timestamp", "ts"}
+	defaultLevelFields     = []string{"level", "severity", "lvl"}
+)
+
+func (c *ResponseConfig) parsed() bool {
+	return c != nil && (c.Format == "json" || c.Format == "ndjson")
+}
+
+// parseRecords splits a json or ndjson body into records. For json bodies
+// it also returns the next-page cursor when pagination.cursor_path is set.
+func (c *ResponseConfig) parseRecords(body []byte) (records []interface{}, cursor string, err error) {
+	switch c.Format {
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, "", fmt.Errorf("parse json response: %w", err)
+		}
+		v, ok := lookupPath(doc, c.RecordsPath)
+		if !ok {
+			return nil, "", fmt.Errorf("parse json response: records_path %q not found", c.RecordsPath)
+		}
+		if records, ok = v.([]interface{}); !ok {
+			return nil, "", fmt.Errorf("parse json response: records_path %q is not an array", c.RecordsPath)
+		}
+		if p := c.Pagination; p != nil && p.CursorPath != "" {
+			cursor, _ = fieldString(doc, p.CursorPath, nil)
+		}
+	case "ndjson":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var rec interface{}
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, "", fmt.Errorf("parse ndjson response: %w", err)
+			}
+			records = append(records, rec)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, "", fmt.Errorf("parse ndjson response: %w", err)
+		}
+	}
+	if c.NewestFirst {
+		slices.Reverse(records)
+	}
+	return records, cursor, nil
+}
+
+// recordLines renders the last n records (all if n <= 0) one per line,
+// "timestamp LEVEL message", so they go through the same redaction and
+// formatting as file tails. Records without a recognizable message are
+// kept as compact JSON.
+func (c *ResponseConfig) recordLines(records []interface{}, n int) string {
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+
+	var b strings.Builder
+	for _, rec := range records {
+		b.WriteString(c.recordLine(rec))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// readRecords fetches and parses the target, following pagination until
+// enough records are collected or pagination.max_pages is reached. Pages of
+// a newest_first API get older as they go, so fetching stops as soon as
+// lines records are in hand; otherwise every page is read and the last
+// lines records are kept.
+func (a *APILogSource) readRecords(ctx context.Context, lines int) (string, error) {
+	c := a.Response
+	var all []interface{}
+	pageURL := a.URL
+	for page := 0; page < c.Pagination.maxPages() && pageURL != ""; page++ {
+		body, header, err := a.fetchWithRetry(ctx, pageURL)
+		if err != nil {
+			return "", err
+		}
+		records, cursor, err := c.parseRecords(body)
+		if err != nil {
+			return "", err
+		}
+
+		if c.NewestFirst {
+			all = append(records, all...)
+			if lines > 0 && len(all) >= lines {
+				break
+			}
+		} else {
+			all = append(all, records...)
+		}
+
+		if pageURL, err = c.Pagination.nextPage(pageURL, cursor, header); err != nil {
+			return "", err
+		}
+	}
+	return c.recordLines(all, lines), nil
+}
+
+// PaginationConfig lets an api target span several responses, either via
+// a cursor in the body or an RFC 8288 Link: <...>; rel="next" header.
+type PaginationConfig struct {
+	// CursorPath is the dotted path to the next cursor in a json body, sent
+	// back as the CursorParam query parameter. Pagination stops when the
+	// cursor is missing or empty.
+	CursorPath  string `yaml:"cursor_path,omitempty" json:"cursor_path,omitempty"`
+	CursorParam string `yaml:"cursor_param,omitempty" json:"cursor_param,omitempty"`
+	// FollowLinkHeader follows rel="next" Link headers.
+	FollowLinkHeader bool `yaml:"follow_link_header,omitempty" json:"follow_link_header,omitempty"`
+	// MaxPages caps requests per read (default 5).
+	MaxPages int `yaml:"max_pages,omitempty" json:"max_pages,omitempty"`
+}
+
+func (p *PaginationConfig) maxPages() int {
+	if p == nil {
+		return 1
+	}
+	if p.MaxPages <= 0 {
+		return 5
+	}
+	return p.MaxPages
+}
+
+var linkNextRegex = regexp.MustCompile(`<([^>]*)>\s*;[^,]*\brel="?next"?`)
+
+// nextPage returns the URL of the page after current, or "" at the end.
+func (p *PaginationConfig) nextPage(current, cursor string, header http.Header) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	if p.FollowLinkHeader {
+		for _, link := range header.Values("Link") {
+			if m := linkNextRegex.FindStringSubmatch(link); m != nil {
+				next, err := base.Parse(m[1])
+				if err != nil {
+					return "", fmt.Errorf("parse next link: %w", err)
+				}
+				return next.String(), nil
+			}
+		}
+	}
+
+	if p.CursorPath != "" && cursor != "" {
+		q := base.Query()
+		q.Set(p.CursorParam, cursor)
+		base.RawQuery = q.Encode()
+		return base.String(), nil
+	}
+	return "", nil
+}
+
+func (c *ResponseConfig) recordLine(rec interface{}) string {
+	msg, ok := fieldString(rec, c.MessageField, defaultMessageFields)
+	if !ok {
+		data, _ := json.Marshal(rec)
+		return string(data)
+	}
+
+	var parts []string
+	if ts, ok := fieldValue(rec, c.TimestampField, defaultTimestampFields); ok {
+		parts = append(parts, formatRecordTime(ts))
+	}
+	if level, ok := fieldString(rec, c.LevelField, defaultLevelFields); ok {
+		parts = append(parts, strings.ToUpper(level))
+	}
+	parts = append(parts, msg)
+	// Records can carry embedded newlines (stack traces); keep one per line.
+	return strings.ReplaceAll(strings.Join(parts, " "), "\n", "\\n")
+}
+
+func fieldValue(rec interface{}, path string, fallbacks []string) (interface{}, bool) {
+	if path != "" {
+		return lookupPath(rec, path)
+	}
+	for _, name := range fallbacks {
+		if v, ok := lookupPath(rec, name); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func fieldString(rec interface{}, path string, fallbacks []string) (string, bool) {
+	v, ok := fieldValue(rec, path, fallbacks)
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	data, _ := json.Marshal(v)
+	return string(data), true
+}
+
+// formatRecordTime renders epoch numbers (seconds or milliseconds) as
+// RFC 3339 so the line is recognized as timestamped; strings are kept.
+func formatRecordTime(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		if t > 1e12 {
+			return time.UnixMilli(int64(t)).UTC().Format(time.RFC3339)
+		}
+		return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// lookupPath walks a dotted path through decoded JSON objects. An empty
+// path returns v itself.
+func lookupPath(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func validateResponseConfig(field string, c *ResponseConfig) []string {
+	var problems []string
+	switch c.Format {
+	case "", "raw", "json", "ndjson":
+	default:
+		problems = append(problems, fmt.Sprintf("%s.format: invalid format %q (expected raw, json or ndjson)", field, c.Format))
+	}
+	if c.RecordsPath != "" && c.Format != "json" {
+		problems = append(problems, field+".records_path: only used with format json")
+	}
+	if p := c.Pagination; p != nil {
+		if !c.parsed() {
+			problems = append(problems, field+".pagination: needs format json or ndjson")
+		}
+		if p.CursorPath != "" && c.Format != "json" {
+			problems = append(problems, field+".pagination.cursor_path: only used with format json")
+		}
+		if (p.CursorPath == "") != (p.CursorParam == "") {
+			problems = append(problems, field+".pagination: set cursor_path and cursor_param together")
+		}
+		if p.CursorPath == "" && !p.FollowLinkHeader {
+			problems = append(problems, field+".pagination: set cursor_path/cursor_param or follow_link_header")
+		}
+		if p.MaxPages < 0 {
+			problems = append(problems, field+".pagination.max_pages: must not be negative")
+		}
+	}
+	return problems
+}
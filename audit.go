@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== AUDIT LOG =====================
+//
+
+type AuditConfig struct {
+	// Path of the JSON-lines audit file. Rotated files get .1, .2, ...
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb,omitempty"`
+	MaxBackups int    `yaml:"max_backups,omitempty"`
+	// MaxAgeDays removes rotated files whose newest entry is older than
+	// this. 0 keeps them until MaxBackups pushes them out.
+	MaxAgeDays int `yaml:"max_age_days,omitempty"`
+}
+
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Target    string    `json:"target,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Bytes     int       `json:"bytes"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// auditInfo travels in the request context so inner middleware (auth) can
+// report who the caller turned out to be, even though the audit middleware
+// runs outermost to also capture rejected requests.
+type auditInfo struct {
+	principal string
+}
+
+type auditInfoKey struct{}
+
+func setAuditPrincipal(ctx context.Context, name string) {
+	if info, ok := ctx.Value(auditInfoKey{}).(*auditInfo); ok {
+		info.principal = name
+	}
+}
+
+// statusRecorder captures the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines on the
+// underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		if cfg == nil || cfg.Audit == nil || r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		info := &auditInfo{}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditInfoKey{}, info)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		entry := AuditEntry{
+			Time:      start.UTC(),
+			Principal: info.principal,
+			RemoteIP:  remoteIP(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Target:    readTargetKey(r),
+			Status:    rec.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:     rec.bytes,
+			RequestID: requestIDFromContext(r.Context()),
+		}
+		if err := auditLog.write(cfg.Audit, entry); err != nil {
+			slog.Error("audit log write failed", "error", err)
+		}
+	})
+}
+
+type auditWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+var auditLog = &auditWriter{}
+
+func (a *auditWriter) write(c *AuditConfig, entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil || a.path != c.Path {
+		if err := a.open(c.Path); err != nil {
+			return err
+		}
+	}
+
+	maxSize := int64(c.MaxSizeMB) << 20
+	if maxSize <= 0 {
+		maxSize = 100 << 20
+	}
+	if a.size+int64(len(line)) > maxSize {
+		if err := a.rotate(c); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+func (a *auditWriter) open(path string) error {
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.path, a.size = f, path, info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 -> path.N down to path -> path.1 and drops
+// anything beyond MaxBackups.
+func (a *auditWriter) rotate(c *AuditConfig) error {
+	backups := c.MaxBackups
+	if backups <= 0 {
+		backups = 5
+	}
+
+	a.file.Close()
+	a.file = nil
+
+	os.Remove(a.path + "." + strconv.Itoa(backups))
+	for i := backups - 1; i >= 1; i-- {
+		os.Rename(a.path+"."+strconv.Itoa(i), a.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(a.path, a.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return a.open(a.path)
+}
+
+// retentionStats counts what the janitor purged; it shows up in
+// /debug/vars.
+var retentionStats = expvar.NewMap("retention")
+
+// startRetentionJanitor enforces audit.max_age_days hourly for the life of
+// the process, picking up config changes on reload.
+func startRetentionJanitor() {
+	go func() {
+		for {
+			if cfg := getConfig(); cfg != nil && cfg.Audit != nil && cfg.Audit.MaxAgeDays > 0 {
+				purgeAuditBackups(cfg.Audit, time.Now())
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// purgeAuditBackups removes rotated audit files last written before the
+// retention window. The live file is left to size-based rotation.
+func purgeAuditBackups(c *AuditConfig, now time.Time) {
+	cutoff := now.Add(-time.Duration(c.MaxAgeDays) * 24 * time.Hour)
+	backups, _ := filepath.Glob(c.Path + ".*")
+	for _, path := range backups {
+		if _, err := strconv.Atoi(strings.TrimPrefix(path, c.Path+".")); err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Warn("audit retention: remove failed", "path", path, "error", err)
+			continue
+		}
+		retentionStats.Add("audit_files_purged", 1)
+		retentionStats.Add("audit_bytes_purged", info.Size())
+		slog.Info("audit retention: removed expired file", "path", path, "modified", info.ModTime())
+	}
+}
+
+func (a *auditWriter) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// auditHandler returns the most recent audit entries from the current file,
+// newest last, optionally filtered by principal or target.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil || cfg.Audit == nil {
+		writeError(w, r, http.StatusNotFound, "audit log is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	principal := q.Get("principal")
+	target := q.Get("target")
+
+	f, err := os.Open(cfg.Audit.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeJSON(w, http.StatusOK, []AuditEntry{})
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("open audit log: %v", err))
+		return
+	}
+	defer f.Close()
+
+	entries := make([]AuditEntry, 0, limit)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if principal != "" && e.Principal != principal {
+			continue
+		}
+		if target != "" && e.Target != target {
+			continue
+		}
+		if len(entries) == limit {
+			entries = append(entries[:0], entries[1:]...)
+		}
+		entries = append(entries, e)
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func validateAuditConfig(c *AuditConfig) []string {
+	var problems []string
+	if c.Path == "" {
+		problems = append(problems, "audit.path: required when the audit section is present")
+	}
+	if c.MaxSizeMB < 0 {
+		problems = append(problems, "audit.max_size_mb: must not be negative")
+	}
+	if c.MaxBackups < 0 {
+		problems = append(problems, "audit.max_backups: must not be negative")
+	}
+	if c.MaxAgeDays < 0 {
+		problems = append(problems, "audit.max_age_days: must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//
+// ===================== AUTH =====================
+//
+
+const (
+	scopeRead   = "read"
+	scopeAction = "action"
+)
+
+type AuthConfig struct {
+	Keys []APIKeyConfig `yaml:"keys,omitempty"`
+	JWT  *JWTConfig     `yaml:"jwt,omitempty"`
+}
+
+func (a *AuthConfig) enabled() bool {
+	return a != nil && (len(a.Keys) > 0 || a.JWT != nil)
+}
+
+// APIKeyConfig is one client credential. Either Key (plaintext, usually
+// injected via ${ENV} or key_file) or KeySHA256 (hex digest, e.g. from
+// `printf %s "$KEY" | sha256sum`) must be set.
+type APIKeyConfig struct {
+	Name      string `yaml:"name"`
+	Key       string `yaml:"key,omitempty"`
+	KeySHA256 string `yaml:"key_sha256,omitempty"`
+	// Scope is "read" (default) or "action". Action keys may also read.
+	Scope string `yaml:"scope,omitempty"`
+}
+
+func (k APIKeyConfig) scope() string {
+	if k.Scope == "" {
+		return scopeRead
+	}
+	return k.Scope
+}
+
+func (k APIKeyConfig) digest() []byte {
+	if k.KeySHA256 != "" {
+		d, _ := hex.DecodeString(k.KeySHA256)
+		return d
+	}
+	d := sha256.Sum256([]byte(k.Key))
+	return d[:]
+}
+
+// Principal identifies the caller of a request after authentication.
+type Principal struct {
+	Name  string
+	Scope string
+	Roles []string
+	// Tenant is set for keys that belong to a tenant.
+	Tenant string
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// isActionRequest reports whether r can change state on the host (apply
+// fixes, rewrite config) or reach admin data, as opposed to only reading
+// logs.
+func isActionRequest(r *http.Request) bool {
+	switch {
+	case r.URL.Path == "/logs/apply-patch", r.URL.Path == "/config/reload":
+		return true
+	case strings.HasPrefix(r.URL.Path, "/admin/"), strings.HasPrefix(r.URL.Path, "/debug/"):
+		return true
+	case strings.HasPrefix(r.URL.Path, "/config/"), r.URL.Path == "/baselines", r.URL.Path == "/level-overrides":
+		return r.Method != http.MethodGet && r.Method != http.MethodHead
+	}
+	return false
+}
+
+// authMiddleware enforces API keys and/or JWTs on every route except
+// /health, which stays open for liveness probes, and the static dashboard
+// pages. With no auth configured the agent keeps its historical open
+// behavior.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		if cfg == nil || !(cfg.Auth.enabled() || cfg.hasTenantKeys()) || r.URL.Path == "/health" || isUIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := requestToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="opscure-agent"`)
+			writeError(w, r, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+
+		principal, err := authenticate(r.Context(), cfg, token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="opscure-agent", error="invalid_token"`)
+			writeError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		setAuditPrincipal(r.Context(), principal.Name)
+
+		if isActionRequest(r) && principal.Scope != scopeAction {
+			writeError(w, r, http.StatusForbidden, fmt.Sprintf("%q is not allowed to perform actions", principal.Name))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate resolves token to a principal, trying static API keys first,
+// then tenant keys and then, for JWT-shaped tokens, the configured issuer.
+func authenticate(ctx context.Context, cfg *Config, token string) (Principal, error) {
+	a := cfg.Auth
+	if a == nil {
+		a = &AuthConfig{}
+	}
+
+	if key, ok := matchAPIKey(a.Keys, token); ok {
+		return Principal{Name: key.Name, Scope: key.scope()}, nil
+	}
+	if tenant, key, ok := matchTenantKey(cfg.Tenants, token); ok {
+		return Principal{Name: key.Name, Scope: key.scope(), Tenant: tenant}, nil
+	}
+
+	if a.JWT != nil && strings.Count(token, ".") == 2 {
+		p, err := verifyJWT(ctx, a.JWT, token)
+		if err != nil {
+			return Principal{}, fmt.Errorf("invalid token: %w", err)
+		}
+		return p, nil
+	}
+
+	return Principal{}, fmt.Errorf("invalid API key")
+}
+
+// requestToken extracts the presented credential from either
+// "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// matchAPIKey compares digests in constant time and checks every key, so the
+// response time doesn't reveal which (or whether any) key nearly matched.
+func matchAPIKey(keys []APIKeyConfig, token string) (APIKeyConfig, bool) {
+	sum := sha256.Sum256([]byte(token))
+
+	var (
+		found APIKeyConfig
+		ok    bool
+	)
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(sum[:], k.digest()) == 1 && !ok {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+// validateAuthConfig checks the global keys and JWT settings. seenKeys
+// collects key names so tenant keys can't reuse them.
+func validateAuthConfig(a *AuthConfig, seenKeys map[string]bool) []string {
+	problems := validateAPIKeys("auth.keys", a.Keys, seenKeys)
+	if a.JWT != nil {
+		problems = append(problems, validateJWTConfig(a.JWT)...)
+	}
+	return problems
+}
+
+func validateAPIKeys(field string, keys []APIKeyConfig, seen map[string]bool) []string {
+	var problems []string
+	for i, k := range keys {
+		field := fmt.Sprintf("%s[%d]", field, i)
+		if k.Name == "" {
+			problems = append(problems, field+": missing name")
+		} else if seen[k.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate key name %q", field, k.Name))
+		}
+		seen[k.Name] = true
+
+		switch {
+		case k.Key == "" && k.KeySHA256 == "":
+			problems = append(problems, field+": one of key or key_sha256 is required")
+		case k.Key != "" && k.KeySHA256 != "":
+			problems = append(problems, field+": set only one of key or key_sha256")
+		case k.KeySHA256 != "":
+			if d, err := hex.DecodeString(k.KeySHA256); err != nil || len(d) != sha256.Size {
+				problems = append(problems, field+".key_sha256: must be a 64-character hex SHA-256 digest")
+			}
+		}
+
+		if k.Scope != "" && k.Scope != scopeRead && k.Scope != scopeAction {
+			problems = append(problems, fmt.Sprintf("%s.scope: invalid scope %q (expected read or action)", field, k.Scope))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//
+// ===================== BASELINES =====================
+//
+
+// Baseline is the learned normal volume of one file target. GET /baselines
+// lists them, PUT /baselines seeds or resets them (after a known-good
+// deploy, say), and volume.state_file keeps them across restarts.
+type Baseline struct {
+	App     string `json:"app"`
+	Log     string `json:"log"`
+	Service string `json:"service,omitempty"`
+	// BaselineBytes is the usual volume per interval and ActiveRatio the
+	// share of intervals with any writes.
+	BaselineBytes float64 `json:"baseline_bytes"`
+	ActiveRatio   float64 `json:"active_ratio"`
+	// Samples counts the intervals behind the baseline. Seeding without
+	// it arms the target at once.
+	Samples int `json:"samples"`
+	// Reset, on PUT, forgets the baseline so the target warms up again.
+	Reset bool `json:"reset,omitempty"`
+}
+
+type baselineState struct {
+	SavedAt   time.Time  `json:"saved_at"`
+	Baselines []Baseline `json:"baselines"`
+}
+
+func (s *VolumeStats) baseline() Baseline {
+	return Baseline{
+		App:           s.App,
+		Log:           s.Log,
+		Service:       s.Service,
+		BaselineBytes: s.BaselineBytes,
+		ActiveRatio:   s.active,
+		Samples:       s.Samples,
+	}
+}
+
+// seed replaces the learned baseline with b.
+func (s *VolumeStats) seed(v *VolumeConfig, b Baseline, now time.Time) {
+	s.BaselineBytes, s.active, s.activeBefore = b.BaselineBytes, b.ActiveRatio, b.ActiveRatio
+	s.Samples, s.emptyStreak, s.LastBytes = b.Samples, 0, 0
+	if b.Reset {
+		s.BaselineBytes, s.active, s.activeBefore, s.Samples = 0, 0, 0, 0
+	}
+	s.State = volumeOK
+	if s.Samples < v.warmupIntervals() {
+		s.State = volumeWarmingUp
+	}
+	s.Since = now
+}
+
+// seedBaselines applies baselines to the volume stats. Targets not sampled
+// yet start from the seeded values once their size is known. The caller
+// holds volumeMu.
+func seedBaselines(cfg *Config, baselines []Baseline, now time.Time) {
+	for _, b := range baselines {
+		t := fanOutTarget{app: b.App, key: b.Log}
+		s, ok := volumeStats[t]
+		if !ok {
+			s = &VolumeStats{App: b.App, Log: b.Log, Service: cfg.Apps[b.App].Logs[b.Log].Service}
+			volumeStats[t] = s
+		}
+		s.seed(cfg.Volume, b, now)
+	}
+}
+
+// isVolumeTarget reports whether app/log is a local file target the volume
+// monitor samples.
+func (cfg *Config) isVolumeTarget(app, key string) bool {
+	target, ok := cfg.Apps[app].Logs[key]
+	if !ok {
+		return false
+	}
+	_, ok = targetLocalPath(target)
+	return ok
+}
+
+// loadBaselines restores the baselines saved at path. Entries for targets
+// that are gone from the config are dropped.
+func loadBaselines(cfg *Config, path string, now time.Time) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	var state baselineState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		slog.Warn("volume baselines not loaded", "path", path, "error", err)
+		return
+	}
+
+	var keep []Baseline
+	for _, b := range state.Baselines {
+		if cfg.isVolumeTarget(b.App, b.Log) {
+			keep = append(keep, b)
+		}
+	}
+	volumeMu.Lock()
+	seedBaselines(cfg, keep, now)
+	volumeMu.Unlock()
+	slog.Info("volume baselines loaded", "path", path, "targets", len(keep), "saved_at", state.SavedAt)
+}
+
+// saveBaselines writes the current baselines to volume.state_file, through
+// a temporary file so a crash never leaves half a file behind.
+func saveBaselines(cfg *Config) {
+	if cfg == nil || cfg.Volume == nil || cfg.Volume.StateFile == "" {
+		return
+	}
+	path := cfg.Volume.StateFile
+
+	volumeMu.Lock()
+	state := baselineState{SavedAt: time.Now().UTC(), Baselines: make([]Baseline, 0, len(volumeStats))}
+	for _, s := range volumeStats {
+		state.Baselines = append(state.Baselines, s.baseline())
+	}
+	volumeMu.Unlock()
+	sortBaselines(state.Baselines)
+
+	err := func() error {
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".baselines-*.json")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(append(data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	}()
+	if err != nil {
+		slog.Warn("volume baselines not saved", "path", path, "error", err)
+	}
+}
+
+func sortBaselines(baselines []Baseline) {
+	sort.Slice(baselines, func(i, j int) bool {
+		if baselines[i].App != baselines[j].App {
+			return baselines[i].App < baselines[j].App
+		}
+		return baselines[i].Log < baselines[j].Log
+	})
+}
+
+// baselinesHandler lists baselines on GET (optionally for one app= and
+// log=) and seeds or resets them on PUT with a JSON array of baselines.
+func baselinesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET and PUT allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil || cfg.Volume == nil {
+		writeError(w, r, http.StatusNotFound, "volume monitoring is not configured")
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var baselines []Baseline
+		if err := json.NewDecoder(r.Body).Decode(&baselines); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		for i, b := range baselines {
+			if !appVisible(r.Context(), cfg, b.App) || !cfg.isVolumeTarget(b.App, b.Log) {
+				writeErrorFor(w, r, errNotFound(fmt.Sprintf("no file target %q for app %q", b.Log, b.App)))
+				return
+			}
+			switch {
+			case b.BaselineBytes < 0:
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("[%d].baseline_bytes: must not be negative", i))
+				return
+			case b.ActiveRatio < 0 || b.ActiveRatio > 1:
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("[%d].active_ratio: must be between 0 and 1", i))
+				return
+			case b.Samples < 0:
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("[%d].samples: must not be negative", i))
+				return
+			}
+			if b.Samples == 0 && !b.Reset {
+				baselines[i].Samples = cfg.Volume.warmupIntervals()
+			}
+		}
+
+		volumeMu.Lock()
+		seedBaselines(cfg, baselines, time.Now())
+		volumeMu.Unlock()
+		saveBaselines(cfg)
+		slog.Info("volume baselines updated", "targets", len(baselines))
+	}
+
+	q := r.URL.Query()
+	stats := volumeSnapshot(r, cfg, q.Get("app"), q.Get("log"))
+	baselines := make([]Baseline, len(stats))
+	for i := range stats {
+		baselines[i] = stats[i].baseline()
+	}
+	writeJSON(w, http.StatusOK, baselines)
+}
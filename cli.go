@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//
+// ===================== CLI =====================
+//
+
+const cliUsage = `usage: agent <command> [flags]
+
+commands:
+  serve           run the HTTP agent (default when no command is given)
+  check-config    validate a config file and exit non-zero on problems
+  tail            print the tail of a configured log or file
+  query           fetch logs from a running agent
+  migrate-config  upgrade a config file to the current schema version
+  version         print build information (also -version)
+
+Run "agent <command> -h" for the flags of a command.
+`
+
+// runCommand dispatches to a subcommand and returns the exit code. Bare
+// flags (or no arguments) mean serve.
+func runCommand(args []string) int {
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		args = []string{"version"}
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "check-config":
+		return runCheckConfig(args[1:])
+	case "tail":
+		return runTail(args[1:])
+	case "query":
+		return runQuery(args[1:])
+	case "migrate-config":
+		return runMigrateConfig(args[1:])
+	case "version":
+		fmt.Println(buildInfo())
+		return 0
+	case "help":
+		fmt.Print(cliUsage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+	return 2
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// runCheckConfig loads a config exactly as serve would, for CI pipelines.
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	path := fs.String("config", "", "path to YAML config file")
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Parse(args)
+
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "check-config: -config is required")
+		return 2
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 1
+	}
+	for _, w := range cfg.warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", *path, w)
+	}
+	if *strict && len(cfg.warnings) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: ok (%d apps)\n", *path, len(cfg.Apps))
+	return 0
+}
+
+// runTail prints the tail of a configured target (app log) or of a file,
+// run through the same redaction and formatting as /logs.
+func runTail(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to YAML config file (for app/log targets)")
+	file := fs.String("file", "", "tail this file instead of a configured target")
+	lines := fs.Int("n", 100, "number of lines (0 for the whole file)")
+	follow := fs.Bool("f", false, "keep printing lines as they are written (sources that can follow)")
+	asJSON := fs.Bool("json", false, "print formatted entries as JSON, one per line")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: agent tail [flags] <app> <log>\n       agent tail [flags] -file <path>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var (
+		cfg    *Config
+		target LogTarget
+		src    LogSource
+		err    error
+	)
+	if *configPath != "" {
+		if cfg, err = loadConfig(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			return 1
+		}
+		setConfig(cfg)
+	}
+
+	switch {
+	case *file != "" && fs.NArg() == 0:
+		src = cfg.readSettings("", "").fileSource(*file)
+	case *file == "" && fs.NArg() == 2 && cfg != nil:
+		app, key := fs.Arg(0), fs.Arg(1)
+		if src, err = sourceFromConfig(cfg, app, key); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			return 1
+		}
+		target = cfg.Apps[app].Logs[key]
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	raw, err := src.ReadLogs(ctx, *lines)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+		return 1
+	}
+	maxLine := cfg.readSettings("", "").MaxLineBytes
+	printLogText(os.Stdout, cfg, redactLogs(cfg, target, sanitizeBinary([]byte(raw))), maxLine, *asJSON)
+
+	if !*follow {
+		return 0
+	}
+	tailable, ok := src.(tailableLogSource)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tail: -f is not supported for %s targets\n", target.Type)
+		return 2
+	}
+	err = tailable.Follow(ctx, func(text string) {
+		printLogText(os.Stdout, cfg, redactLogs(cfg, target, sanitizeBinary([]byte(text))), maxLine, *asJSON)
+	})
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (f *FileLogSource) Follow(ctx context.Context, emit func(text string)) error {
+	return followFile(ctx, f.Path, emit)
+}
+
+// followFile polls path and hands every newly completed chunk of lines to
+// emit until ctx is cancelled. A file that shrinks (truncated or rotated)
+// is followed again from the start.
+func followFile(ctx context.Context, path string, emit func(text string)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue // mid-rotation; try again on the next tick
+		}
+		enc, start, err := detectEncoding(f)
+		if err == nil {
+			offset, err = readNewLines(f, enc, max(offset, start), emit)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readNewLines emits the complete lines written after offset and returns
+// the offset just past the last one.
+func readNewLines(f *os.File, enc textEncoding, offset int64, emit func(text string)) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	unit := enc.unitSize()
+	size := min(info.Size()-offset, 1<<20) / unit * unit
+	if size <= 0 {
+		return offset, nil
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return offset, err
+	}
+
+	end := int64(-1)
+	for i := size - unit; i >= 0; i -= unit {
+		if enc.isNewline(data[i : i+unit]) {
+			end = i + unit
+			break
+		}
+	}
+	if end < 0 {
+		if size == 1<<20 {
+			// A single line over the chunk size; emit it rather than stall.
+			end = size
+		} else {
+			return offset, nil
+		}
+	}
+	emit(decodeText(enc, data[:end]))
+	return offset + end, nil
+}
+
+// printLogText writes redacted log text either as plain lines or as the
+// formatted /logs entries, one JSON object per line.
+func printLogText(w io.Writer, cfg *Config, text string, maxLine int, asJSON bool) {
+	if !asJSON {
+		for _, line := range splitLogLines(text, maxLine) {
+			fmt.Fprintln(w, line)
+		}
+		return
+	}
+
+	output, _ := formatLogOutput(cfg, text, maxLine)
+	enc := json.NewEncoder(w)
+	if entries, ok := output.([]LogOutput); ok {
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+	enc.Encode(output)
+}
+
+// runQuery fetches /logs from a running agent.
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	agentURL := fs.String("url", "http://127.0.0.1:8080", "base URL of the agent")
+	key := fs.String("key", os.Getenv("OPSCURE_API_KEY"), "API key or bearer token (default $OPSCURE_API_KEY)")
+	lines := fs.Int("n", 0, "number of lines (0 means the agent's default)")
+	since := fs.String("since", "", "only lines at or after this time (RFC 3339, Unix seconds, now-1h or -15m)")
+	until := fs.String("until", "", "only lines before this time (RFC 3339, Unix seconds, now-1h or -15m)")
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	timeout := fs.Duration("timeout", 30*time.Second, "request timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: agent query [flags] <app> <log>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	q := url.Values{}
+	q.Set("app", fs.Arg(0))
+	q.Set("log", fs.Arg(1))
+	if *lines > 0 {
+		q.Set("lines", strconv.Itoa(*lines))
+	}
+	if *since != "" {
+		q.Set("since", *since)
+	}
+	if *until != "" {
+		q.Set("until", *until)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(*agentURL, "/")+"/logs?"+q.Encode(), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 2
+	}
+	if *key != "" {
+		req.Header.Set("Authorization", "Bearer "+*key)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: read response: %v\n", err)
+		return 1
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr APIError
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		fmt.Fprintf(os.Stderr, "query: %s: %s\n", resp.Status, apiErr.Message)
+		for _, d := range apiErr.Details {
+			fmt.Fprintf(os.Stderr, "  - %s\n", d)
+		}
+		return 1
+	}
+
+	var entries []LogOutput
+	if *asJSON || json.Unmarshal(body, &entries) != nil {
+		os.Stdout.Write(body)
+		return 0
+	}
+	for _, e := range entries {
+		fmt.Println(e.Raw)
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== CLOCK SKEW =====================
+//
+
+// clockOffsetAuto as a target's clock_offset infers the offset of a local
+// file target from its newest timestamp and the file's modification time,
+// which the agent's host sets when that line is written.
+const clockOffsetAuto = "auto"
+
+const (
+	// clockSkewMinimum is the smallest inferred offset applied; less is
+	// taken for write buffering rather than a wrong clock.
+	clockSkewMinimum = 5 * time.Second
+	// clockSkewTTL is how long an inferred offset is reused.
+	clockSkewTTL = time.Minute
+	// clockSkewSampleLines is how many trailing lines are searched for a
+	// timestamp when inferring.
+	clockSkewSampleLines = 50
+)
+
+type inferredOffset struct {
+	offset time.Duration
+	at     time.Time
+}
+
+var (
+	clockSkewMu    sync.Mutex
+	clockSkewCache = map[fanOutTarget]inferredOffset{}
+)
+
+// clockOffset is what to add to a target's timestamps to get agent time: a
+// host whose clock runs 4 minutes fast has an offset of -4m. Reads shift
+// since/until by it and searches stamp matches with corrected times, so
+// timelines merged across targets keep their order.
+func (cfg *Config) clockOffset(ctx context.Context, app, key string) time.Duration {
+	if cfg == nil {
+		return 0
+	}
+	target := cfg.Apps[app].Logs[key]
+	switch target.ClockOffset {
+	case "":
+		return 0
+	case clockOffsetAuto:
+		path, ok := targetLocalPath(target)
+		if !ok {
+			return 0
+		}
+		return inferClockOffset(ctx, fanOutTarget{app: app, key: key}, path, cfg.readSettings(app, key).MaxLineBytes)
+	}
+	d, _ := time.ParseDuration(target.ClockOffset)
+	return d
+}
+
+// inferClockOffset compares the newest timestamp in a file with its
+// modification time, reusing the answer for clockSkewTTL.
+func inferClockOffset(ctx context.Context, t fanOutTarget, path string, maxLine int) time.Duration {
+	clockSkewMu.Lock()
+	cached, ok := clockSkewCache[t]
+	clockSkewMu.Unlock()
+	if ok && time.Since(cached.at) < clockSkewTTL {
+		return cached.offset
+	}
+
+	offset, err := newestLineOffset(ctx, path, maxLine)
+	if err != nil {
+		slog.DebugContext(ctx, "clock offset not inferred", "app", t.app, "log", t.key, "error", err)
+		offset = cached.offset
+	}
+
+	clockSkewMu.Lock()
+	clockSkewCache[t] = inferredOffset{offset: offset, at: time.Now()}
+	clockSkewMu.Unlock()
+	if offset != cached.offset {
+		slog.Info("clock offset inferred", "app", t.app, "log", t.key, "offset", offset.String())
+	}
+	return offset
+}
+
+func newestLineOffset(ctx context.Context, path string, maxLine int) (time.Duration, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	text, err := tailFileLines(ctx, path, clockSkewSampleLines, maxLine, tailBlockSize)
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if at, ok := parseLogTime(lines[i]); ok {
+			offset := info.ModTime().Sub(at).Round(time.Second)
+			if offset.Abs() < clockSkewMinimum {
+				return 0, nil
+			}
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("no timestamp in the last %d lines", clockSkewSampleLines)
+}
+
+// targetRange converts since/until from agent time to the target's clock.
+// Zero bounds stay unset.
+func targetRange(since, until time.Time, offset time.Duration) (time.Time, time.Time) {
+	if !since.IsZero() {
+		since = since.Add(-offset)
+	}
+	if !until.IsZero() {
+		until = until.Add(-offset)
+	}
+	return since, until
+}
+
+func validateClockOffset(field string, target LogTarget) []string {
+	switch target.ClockOffset {
+	case "":
+		return nil
+	case clockOffsetAuto:
+		if _, ok := targetLocalPath(target); !ok {
+			return []string{field + ".clock_offset: auto needs a target backed by a local file, such as type file"}
+		}
+		return nil
+	}
+	if _, err := time.ParseDuration(target.ClockOffset); err != nil {
+		return []string{fmt.Sprintf("%s.clock_offset: expected a duration such as -4m or auto, got %q", field, target.ClockOffset)}
+	}
+	return nil
+}
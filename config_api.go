@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+//
+// ===================== CONFIG API =====================
+//
+
+// Runtime changes made through these endpoints live in memory only unless the
+// request carries ?persist=true, in which case the same change is written to
+// the config file. Unpersisted changes are lost on the next reload.
+
+// clone returns a copy of cfg that can be modified without affecting readers
+// holding the original snapshot. Every field carries over; only the maps
+// updateConfig's mutations write to, apps with their targets and the app
+// sources, are copied deeply. Sections behind pointers stay shared, so a
+// mutation must replace them rather than edit them in place.
+func (c *Config) clone() *Config {
+	out := *c
+	out.appSources = make(map[string]string, len(c.appSources))
+	for name, file := range c.appSources {
+		out.appSources[name] = file
+	}
+	out.Apps = make(map[string]AppConfig, len(c.Apps))
+	for name, app := range c.Apps {
+		logs := make(map[string]LogTarget, len(app.Logs))
+		for key, target := range app.Logs {
+			logs[key] = target
+		}
+		app.Logs = logs
+		out.Apps[name] = app
+	}
+	return &out
+}
+
+// updateConfig applies mutate to a copy of the active config, validates the
+// result, optionally persists it, and only then swaps it in.
+func updateConfig(mutate func(cfg *Config) error, persist func() error) (*Config, error) {
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	var next *Config
+	if cur := getConfig(); cur != nil {
+		next = cur.clone()
+	} else {
+		next = &Config{}
+		applyConfigDefaults(next)
+	}
+
+	if err := mutate(next); err != nil {
+		return nil, err
+	}
+	applyTargetDefaults(next)
+
+	warnings, err := validateConfig(next)
+	if err != nil {
+		return nil, err
+	}
+	next.warnings = warnings
+
+	if persist != nil {
+		if err := persist(); err != nil {
+			return nil, errInternal{fmt.Errorf("persist config: %w", err)}
+		}
+	}
+
+	setConfig(next)
+	return next, nil
+}
+
+func wantsPersist(r *http.Request) (bool, error) {
+	if r.URL.Query().Get("persist") != "true" {
+		return false, nil
+	}
+	if configFilePath == "" {
+		return false, fmt.Errorf("persist=true requires the agent to be started with -config")
+	}
+	return true, nil
+}
+
+// maskedApp returns app as the config API shows it, with the HTTP secrets
+// of its targets masked.
+func maskedApp(app AppConfig) AppConfig {
+	if app.Logs == nil {
+		return app
+	}
+	logs := make(map[string]LogTarget, len(app.Logs))
+	for key, target := range app.Logs {
+		logs[key] = maskedTarget(target)
+	}
+	app.Logs = logs
+	return app
+}
+
+func maskedTarget(target LogTarget) LogTarget {
+	target.HTTP = target.HTTP.masked()
+	return target
+}
+
+func configAppsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	apps := map[string]AppConfig{}
+	if cfg := getConfig(); cfg != nil {
+		for name, app := range cfg.Apps {
+			if appVisible(r.Context(), cfg, name) {
+				apps[name] = maskedApp(app)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"names": names,
+		"apps":  apps,
+	})
+}
+
+func configAppHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		cfg := getConfig()
+		if cfg == nil {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown app %q", name))
+			return
+		}
+		app, ok := cfg.Apps[name]
+		if !ok || !appVisible(r.Context(), cfg, name) {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown app %q", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, maskedApp(app))
+
+	case http.MethodPut:
+		var app AppConfig
+		if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		persist, err := wantsPersist(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var persistFn func() error
+		if persist {
+			persistFn = func() error { return persistConfigEntry(name, "", app, false) }
+		}
+		cfg, err := updateConfig(func(cfg *Config) error {
+			if cfg.Apps == nil {
+				cfg.Apps = map[string]AppConfig{}
+			}
+			for key, target := range app.Logs {
+				target.HTTP.keepSecrets(cfg.Apps[name].Logs[key].HTTP)
+			}
+			cfg.Apps[name] = app
+			return nil
+		}, persistFn)
+		if err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, maskedApp(cfg.Apps[name]))
+
+	case http.MethodDelete:
+		persist, err := wantsPersist(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var persistFn func() error
+		if persist {
+			persistFn = func() error { return persistConfigEntry(name, "", nil, true) }
+		}
+		_, err = updateConfig(func(cfg *Config) error {
+			if _, ok := cfg.Apps[name]; !ok {
+				return errNotFound(fmt.Sprintf("unknown app %q", name))
+			}
+			delete(cfg.Apps, name)
+			delete(cfg.appSources, name)
+			return nil
+		}, persistFn)
+		if err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET, PUT and DELETE allowed")
+	}
+}
+
+func configLogTargetHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	key := r.PathValue("key")
+
+	switch r.Method {
+	case http.MethodGet:
+		cfg := getConfig()
+		if cfg == nil {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown app %q", name))
+			return
+		}
+		target, ok := cfg.Apps[name].Logs[key]
+		if !ok || !appVisible(r.Context(), cfg, name) {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown log key %q for app %q", key, name))
+			return
+		}
+		writeJSON(w, http.StatusOK, maskedTarget(target))
+
+	case http.MethodPut:
+		var target LogTarget
+		if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		persist, err := wantsPersist(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var persistFn func() error
+		if persist {
+			persistFn = func() error { return persistConfigEntry(name, key, target, false) }
+		}
+		cfg, err := updateConfig(func(cfg *Config) error {
+			if cfg.Apps == nil {
+				cfg.Apps = map[string]AppConfig{}
+			}
+			app := cfg.Apps[name]
+			if app.Logs == nil {
+				app.Logs = map[string]LogTarget{}
+			}
+			target.HTTP.keepSecrets(app.Logs[key].HTTP)
+			app.Logs[key] = target
+			cfg.Apps[name] = app
+			return nil
+		}, persistFn)
+		if err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, maskedTarget(cfg.Apps[name].Logs[key]))
+
+	case http.MethodDelete:
+		persist, err := wantsPersist(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var persistFn func() error
+		if persist {
+			persistFn = func() error { return persistConfigEntry(name, key, nil, true) }
+		}
+		_, err = updateConfig(func(cfg *Config) error {
+			if _, ok := cfg.Apps[name].Logs[key]; !ok {
+				return errNotFound(fmt.Sprintf("unknown log key %q for app %q", key, name))
+			}
+			delete(cfg.Apps[name].Logs, key)
+			return nil
+		}, persistFn)
+		if err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET, PUT and DELETE allowed")
+	}
+}
+
+// persistConfigEntry edits the apps section of the config file in place.
+// It works on the raw YAML tree rather than re-serializing Config, so
+// ${ENV} references, *_file secrets and comments elsewhere in the file are
+// left exactly as the operator wrote them. An empty key targets the whole app.
+// Apps that came from an included file are written back to that file.
+func persistConfigEntry(app, key string, value interface{}, remove bool) error {
+	path := configFilePath
+	if cfg := getConfig(); cfg != nil && cfg.appSources[app] != "" {
+		path = cfg.appSources[app]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+
+	root := doc.Content[0]
+	apps := yamlMappingChild(root, "apps", !remove)
+	if apps == nil {
+		return nil
+	}
+
+	parent, entry := apps, app
+	if key != "" {
+		appNode := yamlMappingChild(apps, app, !remove)
+		if appNode == nil {
+			return nil
+		}
+		parent = yamlMappingChild(appNode, "logs", !remove)
+		if parent == nil {
+			return nil
+		}
+		entry = key
+	}
+
+	if remove {
+		yamlMappingDelete(parent, entry)
+	} else {
+		var valueNode yaml.Node
+		if err := valueNode.Encode(value); err != nil {
+			return err
+		}
+		keepYAMLRefs(&valueNode, yamlMappingChild(parent, entry, false), filepath.Dir(path))
+		yamlMappingSet(parent, entry, &valueNode)
+	}
+
+	return writeYAMLFile(path, &doc)
+}
+
+// keepYAMLRefs puts back the scalars of old, the entry being replaced,
+// wherever node still holds the value they resolve to. Secrets kept by
+// keepSecrets and other unchanged settings are then written as ${ENV}
+// references or *_file paths again rather than as their resolved values.
+func keepYAMLRefs(node, old *yaml.Node, baseDir string) {
+	if node.Kind != yaml.MappingNode || old == nil || old.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if prev := yamlMappingChild(old, key.Value, false); prev != nil {
+			switch {
+			case value.Kind == yaml.MappingNode:
+				keepYAMLRefs(value, prev, baseDir)
+			case value.Kind == yaml.ScalarNode && prev.Kind == yaml.ScalarNode:
+				if expanded, missing := expandEnvRefs(prev.Value); len(missing) == 0 && expanded == value.Value {
+					node.Content[i+1] = prev
+				}
+			}
+			continue
+		}
+		prev := yamlMappingChild(old, key.Value+secretFileSuffix, false)
+		if prev == nil || prev.Kind != yaml.ScalarNode || value.Kind != yaml.ScalarNode {
+			continue
+		}
+		if secret, err := readSecretFile(prev.Value, baseDir); err == nil && secret == value.Value {
+			key.Value += secretFileSuffix
+			node.Content[i+1] = prev
+		}
+	}
+}
+
+// yamlMappingChild returns the value node for key in a mapping node,
+// creating an empty mapping if create is set and the key is missing.
+func yamlMappingChild(mapping *yaml.Node, key string, create bool) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			child := mapping.Content[i+1]
+			if child.Kind != yaml.MappingNode && create {
+				*child = yaml.Node{Kind: yaml.MappingNode}
+			}
+			return child
+		}
+	}
+	if !create {
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	yamlMappingSet(mapping, key, child)
+	return child
+}
+
+func yamlMappingSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+func yamlMappingDelete(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// writeYAMLFile writes doc next to path and renames it into place, so a
+// crash mid-write never leaves a truncated config behind.
+func writeYAMLFile(path string, doc *yaml.Node) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := yaml.NewEncoder(tmp)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,51 @@
+package main
+
+import "strings"
+
+//
+// ===================== TARGET DEFAULTS =====================
+//
+
+// TargetDefaults fills in log target fields that an app leaves empty. String
+// values may use {app} and {key} placeholders, so a single
+// `path: /var/log/{app}/{key}.log` covers every file target on a host.
+type TargetDefaults struct {
+	Type    string `yaml:"type,omitempty" json:"type,omitempty"`
+	Path    string `yaml:"path,omitempty" json:"path,omitempty"`
+	URL     string `yaml:"url,omitempty" json:"url,omitempty"`
+	Service string `yaml:"service,omitempty" json:"service,omitempty"`
+}
+
+// applyTargetDefaults expands placeholders and fills empty target fields
+// from cfg.Defaults. Path and url defaults only apply to targets of the
+// matching type so a file target never inherits a url (or vice versa).
+func applyTargetDefaults(cfg *Config) {
+	d := cfg.Defaults
+	if d == nil {
+		d = &TargetDefaults{}
+	}
+
+	for appName, app := range cfg.Apps {
+		for logKey, target := range app.Logs {
+			if target.Type == "" {
+				target.Type = d.Type
+			}
+			if target.Path == "" && target.Type == "file" {
+				target.Path = d.Path
+			}
+			if target.URL == "" && target.Type == "api" {
+				target.URL = d.URL
+			}
+			if target.Service == "" {
+				target.Service = d.Service
+			}
+
+			r := strings.NewReplacer("{app}", appName, "{key}", logKey)
+			target.Path = r.Replace(target.Path)
+			target.URL = r.Replace(target.URL)
+			target.Service = r.Replace(target.Service)
+
+			app.Logs[logKey] = target
+		}
+	}
+}
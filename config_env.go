@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//
+// ===================== CONFIG INTERPOLATION =====================
+//
+
+// envRefRegex matches ${VAR}, ${VAR:-default} and the $$ escape.
+var envRefRegex = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// secretFileSuffix marks a key whose value is read from a file, e.g.
+// `api_key_file: /run/secrets/ai_key` becomes `api_key: <file contents>`.
+// It only applies where the schema has no field by that name, so real path
+// settings like server.tls.cert_file are left alone.
+const secretFileSuffix = "_file"
+
+// expandConfigNode resolves environment references and secret files in every
+// scalar of a parsed YAML document. It runs before the document is decoded
+// into Config, so it works for any field without per-field plumbing. Relative
+// secret paths are resolved against baseDir (the config file's directory).
+func expandConfigNode(node *yaml.Node, baseDir string) error {
+	var problems ConfigProblems
+	walkConfigNode(node, reflect.TypeOf(Config{}), baseDir, &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// walkConfigNode walks node alongside t, the Go type it will decode into,
+// so secret references can be told apart from genuine *_file fields. t is
+// nil for parts of the document that don't map onto the schema.
+func walkConfigNode(node *yaml.Node, t reflect.Type, baseDir string, problems *ConfigProblems) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkConfigNode(child, t, baseDir, problems)
+		}
+	case yaml.SequenceNode:
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for _, child := range node.Content {
+			walkConfigNode(child, elem, baseDir, problems)
+		}
+	case yaml.MappingNode:
+		keys := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keys[node.Content[i].Value] = true
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			var fieldType reflect.Type
+			isField := false
+			switch {
+			case t != nil && t.Kind() == reflect.Map:
+				fieldType, isField = t.Elem(), true
+			case t != nil && t.Kind() == reflect.Struct:
+				fieldType, isField = yamlFieldType(t, key.Value)
+			}
+			walkConfigNode(value, fieldType, baseDir, problems)
+
+			if isField || t == nil || t.Kind() != reflect.Struct || value.Kind != yaml.ScalarNode {
+				continue
+			}
+			name, ok := strings.CutSuffix(key.Value, secretFileSuffix)
+			if !ok || name == "" {
+				continue
+			}
+			if _, ok := yamlFieldType(t, name); !ok {
+				continue
+			}
+			if keys[name] {
+				*problems = append(*problems, fmt.Sprintf("line %d: both %s and %s are set; use only one", key.Line, name, key.Value))
+				continue
+			}
+			secret, err := readSecretFile(value.Value, baseDir)
+			if err != nil {
+				*problems = append(*problems, fmt.Sprintf("line %d: %s: %v", key.Line, key.Value, err))
+				continue
+			}
+			key.Value = name
+			value.Value = secret
+			value.Tag = "!!str"
+			value.Style = yaml.DoubleQuotedStyle
+		}
+	case yaml.ScalarNode:
+		expanded, missing := expandEnvRefs(node.Value)
+		for _, name := range missing {
+			*problems = append(*problems, fmt.Sprintf("line %d: environment variable %s is not set", node.Line, name))
+		}
+		if expanded != node.Value {
+			node.Value = expanded
+			if node.Style == 0 {
+				// Let plain scalars re-resolve so ${PORT} can fill an int field.
+				node.Tag = ""
+			}
+		}
+	}
+}
+
+// yamlFieldType finds the field of struct t decoded from the yaml key name,
+// looking through ",inline" embedded structs.
+func yamlFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("yaml")
+		if !ok {
+			continue
+		}
+		tagName, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			if ft, ok := yamlFieldType(f.Type, name); ok {
+				return ft, true
+			}
+			continue
+		}
+		if tagName == name {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+// expandEnvRefs substitutes ${VAR} and ${VAR:-default} references. A set
+// but empty VAR expands to nothing unless a default is given, as in the
+// shell. Variables that are unset and have no default are returned in
+// missing. $$ is only an escape in scalars containing "${", so a literal $$
+// in an older config, such as in a regex or password, keeps its meaning.
+func expandEnvRefs(s string) (expanded string, missing []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	expanded = envRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := envRefRegex.FindStringSubmatch(ref)
+		v, ok := os.LookupEnv(m[1])
+		if ok && (v != "" || m[2] == "") {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		missing = append(missing, m[1])
+		return ""
+	})
+	return expanded, missing
+}
+
+func readSecretFile(path, baseDir string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty secret file path")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// ===================== ENV-ONLY CONFIG =====================
+//
+
+// envConfigPrefix marks the variables read when the agent starts without
+// -config, for containers where mounting a YAML file is awkward:
+//
+//	GOAGENT_ADDR=0.0.0.0:8080
+//	GOAGENT_DEFAULT_LINES / GOAGENT_MAX_LINES / GOAGENT_MAX_LINE_BYTES
+//	GOAGENT_LOG_LEVEL / GOAGENT_LOG_FORMAT
+//	GOAGENT_API_KEY (read scope) / GOAGENT_ADMIN_API_KEY (action scope)
+//	GOAGENT_APP_<APP>_LOG_<KEY>_PATH=/var/log/app.log   (file target)
+//	GOAGENT_APP_<APP>_LOG_<KEY>_URL=https://...         (api target)
+//	GOAGENT_APP_<APP>_LOG_<KEY>_SERVICE=PaymentAPI
+//
+// App and log names are lowercased. Anything beyond this needs a config
+// file.
+const envConfigPrefix = "GOAGENT_"
+
+// configFromEnv builds a config from GOAGENT_* variables in environ. ok is
+// false when none are set, so the agent keeps its unconfigured behavior.
+func configFromEnv(environ []string) (cfg *Config, ok bool, err error) {
+	vars := map[string]string{}
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, envConfigPrefix) {
+			vars[strings.TrimPrefix(name, envConfigPrefix)] = value
+		}
+	}
+	if len(vars) == 0 {
+		return nil, false, nil
+	}
+
+	cfg = &Config{Server: &ServerConfig{}, Apps: map[string]AppConfig{}}
+	var problems ConfigProblems
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := vars[name]
+		switch name {
+		case "ADDR":
+			cfg.Server.Addr = value
+		case "DEFAULT_LINES", "MAX_LINES", "MAX_LINE_BYTES":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s%s: not a number: %q", envConfigPrefix, name, value))
+				continue
+			}
+			switch name {
+			case "DEFAULT_LINES":
+				cfg.Server.DefaultLines = n
+			case "MAX_LINES":
+				cfg.Server.MaxLines = n
+			default:
+				cfg.Server.MaxLineBytes = n
+			}
+		case "LOG_LEVEL", "LOG_FORMAT":
+			if cfg.Logging == nil {
+				cfg.Logging = &LoggingConfig{}
+			}
+			if name == "LOG_LEVEL" {
+				cfg.Logging.Level = value
+			} else {
+				cfg.Logging.Format = value
+			}
+		case "API_KEY", "ADMIN_API_KEY":
+			if cfg.Auth == nil {
+				cfg.Auth = &AuthConfig{}
+			}
+			key := APIKeyConfig{Name: "env", Key: value, Scope: scopeRead}
+			if name == "ADMIN_API_KEY" {
+				key = APIKeyConfig{Name: "env-admin", Key: value, Scope: scopeAction}
+			}
+			cfg.Auth.Keys = append(cfg.Auth.Keys, key)
+		default:
+			if err := setEnvTarget(cfg, name, value); err != nil {
+				problems = append(problems, envConfigPrefix+name+": "+err.Error())
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, true, problems
+	}
+
+	applyTargetDefaults(cfg)
+	applyConfigDefaults(cfg)
+	warnings, err := validateConfig(cfg)
+	if err != nil {
+		return nil, true, err
+	}
+	cfg.warnings = append(cfg.warnings, warnings...)
+	return cfg, true, nil
+}
+
+// setEnvTarget applies one APP_<APP>_LOG_<KEY>_<FIELD> variable.
+func setEnvTarget(cfg *Config, name, value string) error {
+	rest, ok := strings.CutPrefix(name, "APP_")
+	if !ok {
+		return fmt.Errorf("unknown setting")
+	}
+	app, rest, ok := strings.Cut(rest, "_LOG_")
+	if !ok || app == "" {
+		return fmt.Errorf("expected APP_<APP>_LOG_<KEY>_<FIELD>")
+	}
+	i := strings.LastIndex(rest, "_")
+	if i <= 0 {
+		return fmt.Errorf("expected APP_<APP>_LOG_<KEY>_<FIELD>")
+	}
+	key, field := strings.ToLower(rest[:i]), rest[i+1:]
+	app = strings.ToLower(app)
+
+	appCfg := cfg.Apps[app]
+	if appCfg.Logs == nil {
+		appCfg.Logs = map[string]LogTarget{}
+	}
+	target := appCfg.Logs[key]
+	switch field {
+	case "PATH":
+		target.Path = value
+		if target.Type == "" {
+			target.Type = "file"
+		}
+	case "URL":
+		target.URL = value
+		if target.Type == "" {
+			target.Type = "api"
+		}
+	case "TYPE":
+		target.Type = value
+	case "SERVICE":
+		target.Service = value
+	default:
+		return fmt.Errorf("unknown field %q (expected PATH, URL, TYPE or SERVICE)", field)
+	}
+	appCfg.Logs[key] = target
+	cfg.Apps[app] = appCfg
+	return nil
+}
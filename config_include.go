@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//
+// ===================== CONFIG INCLUDES =====================
+//
+
+// mergeIncludes loads every file referenced by cfg.Include and merges its
+// apps into cfg. Include entries are globs or directories (all *.yaml/*.yml
+// inside), relative to the main config file.
+//
+// Merge rules:
+//   - files are merged in lexical path order, so the result never depends on
+//     directory listing order;
+//   - included files may only define apps; everything else stays in the
+//     main file;
+//   - each app is owned by exactly one file, so defining the same app twice
+//     is a conflict rather than a silent override.
+func mergeIncludes(cfg *Config, mainPath string) error {
+	if len(cfg.Include) == 0 {
+		return nil
+	}
+
+	var problems ConfigProblems
+
+	files, err := resolveIncludePaths(cfg.Include, filepath.Dir(mainPath))
+	if err != nil {
+		return err
+	}
+
+	if cfg.Apps == nil {
+		cfg.Apps = map[string]AppConfig{}
+	}
+
+	for _, file := range files {
+		if filepath.Clean(file) == filepath.Clean(mainPath) {
+			continue
+		}
+
+		inc, err := decodeConfigFile(file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("include %s: %v", file, err))
+			continue
+		}
+		// Anything but apps, the file's version and its migration warnings
+		// makes the include differ from an empty config, so new sections
+		// are covered without listing them here.
+		rest := *inc
+		rest.Version, rest.Apps, rest.warnings = 0, nil, nil
+		if !reflect.DeepEqual(rest, Config{}) {
+			problems = append(problems, fmt.Sprintf("include %s: only apps may be defined in included files", file))
+			continue
+		}
+
+		names := make([]string, 0, len(inc.Apps))
+		for name := range inc.Apps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if owner, ok := cfg.appSources[name]; ok {
+				problems = append(problems, fmt.Sprintf("app %q is defined in both %s and %s", name, owner, file))
+				continue
+			}
+			cfg.Apps[name] = inc.Apps[name]
+			cfg.appSources[name] = file
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func resolveIncludePaths(patterns []string, baseDir string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	var problems ConfigProblems
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+
+		var matches []string
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			for _, ext := range []string{"*.yaml", "*.yml"} {
+				m, _ := filepath.Glob(filepath.Join(pattern, ext))
+				matches = append(matches, m...)
+			}
+		} else {
+			m, err := filepath.Glob(pattern)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("include %q: %v", pattern, err))
+				continue
+			}
+			if len(m) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				problems = append(problems, fmt.Sprintf("include %q: file does not exist", pattern))
+				continue
+			}
+			matches = m
+		}
+
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
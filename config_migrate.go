@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+//
+// ===================== CONFIG VERSIONING =====================
+//
+
+// currentConfigVersion is the config schema this build reads natively.
+// Files without a version field are treated as version 1.
+const currentConfigVersion = 1
+
+// configMigrations[n] upgrades a raw version-n document to version n+1.
+// A breaking config change bumps currentConfigVersion and registers the step
+// that rewrites older files, so existing deployments keep loading.
+var configMigrations = map[int]func(root *yaml.Node) error{}
+
+// migrateConfigNode upgrades doc in place to currentConfigVersion and
+// returns the version it started from.
+func migrateConfigNode(doc *yaml.Node) (int, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return currentConfigVersion, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return 0, fmt.Errorf("top level of config must be a mapping")
+	}
+
+	from := 1
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "version" {
+			continue
+		}
+		v, err := strconv.Atoi(root.Content[i+1].Value)
+		if err != nil || v < 1 {
+			return 0, fmt.Errorf("line %d: version must be a positive integer", root.Content[i+1].Line)
+		}
+		from = v
+	}
+
+	if from > currentConfigVersion {
+		return from, fmt.Errorf("config version %d is newer than this agent supports (max %d); upgrade the agent", from, currentConfigVersion)
+	}
+
+	for v := from; v < currentConfigVersion; v++ {
+		step, ok := configMigrations[v]
+		if !ok {
+			return from, fmt.Errorf("no migration from config version %d to %d", v, v+1)
+		}
+		if err := step(root); err != nil {
+			return from, fmt.Errorf("migrate config v%d to v%d: %w", v, v+1, err)
+		}
+	}
+
+	version := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(currentConfigVersion)}
+	if yamlMappingChild(root, "version", false) != nil {
+		yamlMappingSet(root, "version", version)
+	} else {
+		// Keep the version at the top of the file where people look for it.
+		root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}, version}, root.Content...)
+	}
+	return from, nil
+}
+
+// runMigrateConfig implements the migrate-config command: it upgrades a
+// config file to the current schema, printing the result or rewriting the
+// file in place (keeping a .bak copy of the original).
+func runMigrateConfig(args []string) int {
+	fs := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	path := fs.String("config", "", "path to YAML config file to migrate")
+	write := fs.Bool("write", false, "rewrite the file in place instead of printing it")
+	fs.Parse(args)
+
+	if *path == "" {
+		fmt.Println("migrate-config: -config is required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		fmt.Printf("migrate-config: read config: %v\n", err)
+		return 1
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		fmt.Printf("migrate-config: parse config: %v\n", err)
+		return 1
+	}
+
+	from, err := migrateConfigNode(&doc)
+	if err != nil {
+		fmt.Printf("migrate-config: %v\n", err)
+		return 1
+	}
+
+	if !*write {
+		var out bytes.Buffer
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		enc.Encode(&doc)
+		enc.Close()
+		os.Stdout.Write(out.Bytes())
+		return 0
+	}
+
+	if err := os.WriteFile(*path+".bak", data, 0o600); err != nil {
+		fmt.Printf("migrate-config: write backup: %v\n", err)
+		return 1
+	}
+	if err := writeYAMLFile(*path, &doc); err != nil {
+		fmt.Printf("migrate-config: write config: %v\n", err)
+		return 1
+	}
+	fmt.Printf("migrated %s from version %d to %d (backup at %s.bak)\n", *path, from, currentConfigVersion, *path)
+	return 0
+}
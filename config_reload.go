@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+//
+// ===================== CONFIG RELOAD =====================
+//
+
+var (
+	// activeConfig holds the config every request reads. A config is never
+	// modified once stored; updates swap in a new one.
+	activeConfig     atomic.Pointer[Config]
+	configFilePath   string
+	configGeneration atomic.Uint64
+
+	// configUpdateMu serializes read-modify-write cycles (reloads and the
+	// config API) so concurrent updates can't overwrite each other.
+	configUpdateMu sync.Mutex
+)
+
+// getConfig returns the active config snapshot. Callers should fetch it once
+// per request and keep using that pointer, so a reload half-way through a
+// request never mixes old and new settings.
+func getConfig() *Config {
+	return activeConfig.Load()
+}
+
+// setConfig makes cfg the active config. cfg must be complete: handlers
+// may read it as soon as it is stored.
+func setConfig(cfg *Config) {
+	if cfg != nil {
+		cfg.generation = configGeneration.Add(1)
+	}
+	activeConfig.Store(cfg)
+}
+
+// reloadConfig re-reads the config file the agent was started with and swaps
+// it in only if it loads cleanly; on error the running config is untouched.
+func reloadConfig() (*Config, error) {
+	if configFilePath == "" {
+		return nil, fmt.Errorf("no config file to reload; start server with -config flag")
+	}
+
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	cfg, err := loadConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	mergeDiscovered(cfg)
+	applyTargetDefaults(cfg)
+
+	setConfig(cfg)
+	configureLogging(cfg.Logging)
+	return cfg, nil
+}
+
+func watchReloadSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for range sigs {
+			sdNotify("RELOADING=1")
+			cfg, err := reloadConfig()
+			sdNotify("READY=1")
+			if err != nil {
+				slog.Error("config reload failed, keeping previous config", "error", err)
+				continue
+			}
+			for _, w := range cfg.warnings {
+				slog.Warn("config warning", "problem", w)
+			}
+			slog.Info("config reloaded", "path", configFilePath)
+		}
+	}()
+}
+
+func configReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "only POST allowed")
+		return
+	}
+
+	cfg, err := reloadConfig()
+	if err != nil {
+		writeErrorFor(w, r, fmt.Errorf("reload failed: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "reloaded",
+		"path":     configFilePath,
+		"apps":     len(cfg.Apps),
+		"warnings": cfg.warnings,
+	})
+}
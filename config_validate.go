@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+//
+// ===================== CONFIG VALIDATION =====================
+//
+
+// ConfigProblems collects every validation failure found in a config so the
+// operator can fix them all in one pass instead of one restart per typo.
+type ConfigProblems []string
+
+func (p ConfigProblems) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "config has %d problem(s):", len(p))
+	for _, problem := range p {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// validateConfig checks a parsed config for missing or contradictory
+// settings. Hard problems are returned as a ConfigProblems error; issues that
+// may resolve themselves at runtime (e.g. a log file not created yet) are
+// returned as warnings.
+func validateConfig(cfg *Config) (warnings []string, err error) {
+	var problems ConfigProblems
+
+	if s := cfg.Server; s != nil {
+		if s.Addr != "" {
+			if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+				problems = append(problems, fmt.Sprintf("server.addr: invalid listen address %q: %v", s.Addr, err))
+			}
+		}
+		problems = append(problems, validateReadSettings("server", s.ReadSettings)...)
+		if s.TLS != nil {
+			problems = append(problems, validateTLSConfig(s.TLS)...)
+		}
+		if s.RateLimit != nil {
+			problems = append(problems, validateRateLimit(s.RateLimit)...)
+		}
+		if s.CORS != nil {
+			problems = append(problems, validateCORSConfig(s.CORS)...)
+		}
+		if s.AllowedCIDRs != nil {
+			problems = append(problems, validateAllowedCIDRs(s.AllowedCIDRs)...)
+		}
+		if s.FanOut != nil {
+			problems = append(problems, validateFanOutConfig(s.FanOut)...)
+		}
+		if s.Timeouts != nil {
+			problems = append(problems, validateServerTimeouts(s.Timeouts)...)
+		}
+		if s.MaxConnections < 0 {
+			problems = append(problems, "server.max_connections: must not be negative")
+		}
+		if s.UnparsedWarnRatio > 1 {
+			problems = append(problems, "server.unparsed_warn_ratio: must be at most 1")
+		}
+		if s.ShutdownGraceSeconds < 0 {
+			problems = append(problems, "server.shutdown_grace_seconds: must not be negative")
+		}
+	}
+
+	if ai := cfg.AI; ai != nil {
+		if ai.BaseURL == "" {
+			problems = append(problems, "ai.base_url: required when the ai section is present")
+		} else if err := checkHTTPURL(ai.BaseURL); err != nil {
+			problems = append(problems, fmt.Sprintf("ai.base_url: %v", err))
+		}
+		if ai.TimeoutSeconds < 0 {
+			problems = append(problems, "ai.timeout_seconds: must not be negative")
+		}
+	}
+
+	seenKeys := map[string]bool{}
+	if cfg.Auth != nil {
+		problems = append(problems, validateAuthConfig(cfg.Auth, seenKeys)...)
+	}
+	if len(cfg.Tenants) > 0 {
+		p, w := validateTenants(cfg, seenKeys)
+		problems = append(problems, p...)
+		warnings = append(warnings, w...)
+	}
+	if cfg.Redaction != nil {
+		problems = append(problems, validateRedaction(cfg.Redaction)...)
+	}
+	if cfg.Audit != nil {
+		problems = append(problems, validateAuditConfig(cfg.Audit)...)
+	}
+	if cfg.Logging != nil {
+		problems = append(problems, validateLoggingConfig(cfg.Logging)...)
+	}
+	if cfg.Extractors != nil {
+		problems = append(problems, validateExtractors(cfg.Extractors)...)
+	}
+	if cfg.Enrichment != nil {
+		problems = append(problems, validateEnrichment(cfg.Enrichment)...)
+	}
+	if cfg.Volume != nil {
+		problems = append(problems, validateVolumeConfig(cfg.Volume)...)
+	}
+	if cfg.Disk != nil {
+		problems = append(problems, validateDiskConfig(cfg.Disk)...)
+	}
+	problems = append(problems, validateAnalyzers(cfg.Analyzers)...)
+	if cfg.Discovery != nil {
+		problems = append(problems, validateDiscoveryConfig(cfg.Discovery)...)
+	}
+	if cfg.Fleet != nil {
+		problems = append(problems, validateFleetConfig(cfg.Fleet)...)
+	}
+
+	if len(cfg.Apps) == 0 {
+		warnings = append(warnings, "apps: no apps configured; only source= queries will work")
+	}
+
+	appNames := make([]string, 0, len(cfg.Apps))
+	for name := range cfg.Apps {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
+
+	for _, appName := range appNames {
+		appCfg := cfg.Apps[appName]
+		if strings.TrimSpace(appName) == "" {
+			problems = append(problems, "apps: app name must not be empty")
+			continue
+		}
+		problems = append(problems, validateReadSettings("apps."+appName, appCfg.ReadSettings)...)
+		problems = append(problems, validateLabels("apps."+appName+".labels", appCfg.Labels)...)
+		if len(appCfg.Logs) == 0 {
+			warnings = append(warnings, fmt.Sprintf("apps.%s: no logs configured", appName))
+			continue
+		}
+
+		logKeys := make([]string, 0, len(appCfg.Logs))
+		for key := range appCfg.Logs {
+			logKeys = append(logKeys, key)
+		}
+		sort.Strings(logKeys)
+
+		for _, logKey := range logKeys {
+			field := fmt.Sprintf("apps.%s.logs.%s", appName, logKey)
+			if strings.TrimSpace(logKey) == "" {
+				problems = append(problems, fmt.Sprintf("apps.%s.logs: log key must not be empty", appName))
+				continue
+			}
+			p, w := validateLogTarget(field, appCfg.Logs[logKey])
+			problems = append(problems, p...)
+			problems = append(problems, validateReadSettings(field, appCfg.Logs[logKey].ReadSettings)...)
+			problems = append(problems, validateRedactRules(field, appCfg.Logs[logKey].Redact)...)
+			problems = append(problems, validateLabels(field+".labels", appCfg.Logs[logKey].Labels)...)
+			warnings = append(warnings, w...)
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, problems
+	}
+	return warnings, nil
+}
+
+func validateReadSettings(field string, own ReadSettings) []string {
+	var problems []string
+	if own.DefaultLines < 0 {
+		problems = append(problems, field+".default_lines: must not be negative")
+	}
+	if own.MaxLines < 0 {
+		problems = append(problems, field+".max_lines: must not be negative")
+	}
+	if own.MaxLineBytes < 0 {
+		problems = append(problems, field+".max_line_bytes: must not be negative")
+	}
+	if own.MaxReadBytes < 0 {
+		problems = append(problems, field+".max_read_bytes: must not be negative")
+	}
+	if own.ReadTimeoutSeconds < 0 {
+		problems = append(problems, field+".read_timeout_seconds: must not be negative")
+	}
+	if own.DefaultLines > 0 && own.MaxLines > 0 && own.DefaultLines > own.MaxLines {
+		problems = append(problems, fmt.Sprintf("%s.default_lines (%d) is greater than %s.max_lines (%d)", field, own.DefaultLines, field, own.MaxLines))
+	}
+	return problems
+}
+
+func validateLogTarget(field string, target LogTarget) (problems, warnings []string) {
+	if target.Type == "" {
+		problems = append(problems, fmt.Sprintf("%s: missing type (expected %s)", field, sourceTypeList()))
+	} else if st, ok := lookupSourceType(target.Type); ok {
+		problems, warnings = st.validate(field, target)
+	} else {
+		problems = append(problems, fmt.Sprintf("%s: invalid type %q (expected %s)", field, target.Type, sourceTypeList()))
+	}
+	problems = append(problems, validateClockOffset(field, target)...)
+	return problems, warnings
+}
+
+func checkHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q: missing host", raw)
+	}
+	return nil
+}
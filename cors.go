@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//
+// ===================== CORS & SECURITY HEADERS =====================
+//
+
+type CORSConfig struct {
+	// AllowedOrigins lists exact origins, or "*" for any origin.
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `yaml:"allowed_headers,omitempty"`
+	AllowCredentials bool     `yaml:"allow_credentials,omitempty"`
+	MaxAgeSeconds    int      `yaml:"max_age_seconds,omitempty"`
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-API-Key"}
+)
+
+// corsMiddleware answers preflight requests and decorates responses with CORS
+// and security headers. It sits outside auth because browsers never send
+// credentials on a preflight.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		var server *ServerConfig
+		if cfg != nil {
+			server = cfg.Server
+		}
+
+		if server == nil || !server.DisableSecurityHeaders {
+			setSecurityHeaders(w, r)
+		}
+
+		origin := r.Header.Get("Origin")
+		if server == nil || server.CORS == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := server.CORS
+
+		w.Header().Add("Vary", "Origin")
+		if !corsOriginAllowed(c, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if containsString(c.AllowedOrigins, "*") && !c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			methods := c.AllowedMethods
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+			headers := c.AllowedHeaders
+			if len(headers) == 0 {
+				headers = defaultCORSHeaders
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if c.MaxAgeSeconds > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAgeSeconds))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func corsOriginAllowed(c *CORSConfig, origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	if isUIPath(r.URL.Path) {
+		// The dashboard loads its own script and styles and calls the API.
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	} else {
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	}
+	if r.TLS != nil {
+		h.Set("Strict-Transport-Security", "max-age=31536000")
+	}
+}
+
+func validateCORSConfig(c *CORSConfig) []string {
+	var problems []string
+	if len(c.AllowedOrigins) == 0 {
+		problems = append(problems, "server.cors.allowed_origins: required when the cors section is present")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				// Browsers refuse credentials for a wildcard origin, and
+				// echoing each origin instead would trust every site.
+				problems = append(problems, `server.cors.allowed_origins: "*" cannot be combined with allow_credentials; list the origins explicitly`)
+			}
+			continue
+		}
+		if err := checkHTTPURL(origin); err != nil {
+			problems = append(problems, "server.cors.allowed_origins: "+err.Error())
+		}
+	}
+	if c.MaxAgeSeconds < 0 {
+		problems = append(problems, "server.cors.max_age_seconds: must not be negative")
+	}
+	return problems
+}
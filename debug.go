@@ -0,0 +1,34 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+//
+// ===================== DEBUG ENDPOINTS =====================
+//
+
+// registerDebugHandlers mounts pprof and expvar under /debug/. They answer
+// 404 unless server.enable_debug is set, and auth treats them as action
+// routes, since profiles expose memory contents and command lines.
+func registerDebugHandlers(mux *http.ServeMux) {
+	mux.Handle("/debug/pprof/", debugOnly(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", debugOnly(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", debugOnly(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", debugOnly(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", debugOnly(http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/debug/vars", debugOnly(expvar.Handler()))
+}
+
+func debugOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		if cfg == nil || cfg.Server == nil || !cfg.Server.EnableDebug {
+			writeError(w, r, http.StatusNotFound, "debug endpoints are disabled")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== TARGET DISCOVERY =====================
+//
+
+// DiscoveryConfig registers file targets found on the host so they don't
+// all have to be listed by hand. Discovered targets never replace one set
+// in the config; they live only in memory and are rescanned every
+// interval_seconds (default 60).
+type DiscoveryConfig struct {
+	IntervalSeconds int                    `yaml:"interval_seconds,omitempty"`
+	Files           []FileDiscoveryRule    `yaml:"files,omitempty"`
+	Docker          *DockerDiscoveryConfig `yaml:"docker,omitempty"`
+}
+
+// FileDiscoveryRule turns every file matching Glob into a target. App, Log
+// and Service are templates: {name} is the file name without extension,
+// {dir} the parent directory name, and named groups of Match (applied to
+// the file name) are available as {group}. Files Match rejects are skipped.
+//
+// For Kubernetes: glob /var/log/containers/*.log, match
+// ^(?P<pod>[^_]+)_(?P<namespace>[^_]+)_(?P<container>.+)-[0-9a-f]{64}\.log$,
+// app {namespace}, log {pod}-{container}.
+type FileDiscoveryRule struct {
+	Glob    string `yaml:"glob"`
+	Match   string `yaml:"match,omitempty"`
+	App     string `yaml:"app,omitempty"`
+	Log     string `yaml:"log,omitempty"`
+	Service string `yaml:"service,omitempty"`
+}
+
+// DockerDiscoveryConfig registers the json-file log of every running
+// container carrying Label. Templates see {name}, {id}, {image} and each
+// label as {label.<key>}.
+type DockerDiscoveryConfig struct {
+	// Socket defaults to /var/run/docker.sock.
+	Socket string `yaml:"socket,omitempty"`
+	// Label defaults to goagent.log=true.
+	Label   string `yaml:"label,omitempty"`
+	App     string `yaml:"app,omitempty"`
+	Log     string `yaml:"log,omitempty"`
+	Service string `yaml:"service,omitempty"`
+}
+
+func (d *DiscoveryConfig) interval() time.Duration {
+	if d.IntervalSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(d.IntervalSeconds) * time.Second
+}
+
+var (
+	discoveredMu sync.Mutex
+	// discovered holds the latest scan, app -> log key -> target, so a
+	// reload can put the targets back without waiting for the next scan.
+	discovered = map[string]map[string]LogTarget{}
+)
+
+// startDiscovery scans once right away and then every interval for the
+// life of the process, following interval and rule changes on reload.
+func startDiscovery() {
+	go func() {
+		for {
+			cfg := getConfig()
+			if cfg == nil || cfg.Discovery == nil {
+				setDiscovered(map[string]map[string]LogTarget{})
+				time.Sleep(time.Minute)
+				continue
+			}
+			setDiscovered(discoverTargets(cfg.Discovery))
+			time.Sleep(cfg.Discovery.interval())
+		}
+	}()
+}
+
+// setDiscovered swaps in a new scan result and rebuilds the discovered
+// part of the live config from it.
+func setDiscovered(found map[string]map[string]LogTarget) {
+	discoveredMu.Lock()
+	unchanged := reflect.DeepEqual(discovered, found)
+	discovered = found
+	discoveredMu.Unlock()
+	if unchanged {
+		return
+	}
+
+	_, err := updateConfig(func(cfg *Config) error {
+		mergeDiscovered(cfg)
+		return nil
+	}, nil)
+	if err != nil {
+		slog.Warn("discovered targets rejected", "error", err)
+	}
+}
+
+// mergeDiscovered drops the previously discovered targets from cfg and adds
+// the current ones wherever the config doesn't already define that app/log.
+func mergeDiscovered(cfg *Config) {
+	for name, app := range cfg.Apps {
+		removed := false
+		for key, target := range app.Logs {
+			if target.discovered {
+				delete(app.Logs, key)
+				removed = true
+			}
+		}
+		if removed && len(app.Logs) == 0 {
+			delete(cfg.Apps, name)
+		}
+	}
+
+	discoveredMu.Lock()
+	defer discoveredMu.Unlock()
+
+	if len(discovered) > 0 && cfg.Apps == nil {
+		cfg.Apps = map[string]AppConfig{}
+	}
+	for name, logs := range discovered {
+		app := cfg.Apps[name]
+		if app.Logs == nil {
+			app.Logs = map[string]LogTarget{}
+		}
+		for key, target := range logs {
+			if _, ok := app.Logs[key]; !ok {
+				app.Logs[key] = target
+			}
+		}
+		cfg.Apps[name] = app
+	}
+}
+
+// discoverTargets runs every rule. A failing source is logged and skipped
+// so one broken rule doesn't unregister everything else.
+func discoverTargets(d *DiscoveryConfig) map[string]map[string]LogTarget {
+	found := map[string]map[string]LogTarget{}
+	add := func(vars map[string]string, app, log, service, path string) {
+		appName, key := expandTemplate(app, vars), expandTemplate(log, vars)
+		if appName == "" || key == "" {
+			return
+		}
+		if found[appName] == nil {
+			found[appName] = map[string]LogTarget{}
+		}
+		if _, ok := found[appName][key]; ok {
+			return
+		}
+		found[appName][key] = LogTarget{
+			Type:       "file",
+			Path:       path,
+			Service:    expandTemplate(service, vars),
+			discovered: true,
+		}
+	}
+
+	for _, rule := range d.Files {
+		matches, err := filepath.Glob(rule.Glob)
+		if err != nil {
+			slog.Warn("discovery glob failed", "glob", rule.Glob, "error", err)
+			continue
+		}
+		sort.Strings(matches)
+
+		var match *regexp.Regexp
+		if rule.Match != "" {
+			match = regexp.MustCompile(rule.Match)
+		}
+		for _, path := range matches {
+			base := filepath.Base(path)
+			vars := map[string]string{
+				"name": strings.TrimSuffix(base, filepath.Ext(base)),
+				"dir":  filepath.Base(filepath.Dir(path)),
+			}
+			if match != nil {
+				m := match.FindStringSubmatch(base)
+				if m == nil {
+					continue
+				}
+				for i, group := range match.SubexpNames() {
+					if group != "" {
+						vars[group] = m[i]
+					}
+				}
+			}
+			add(vars, defaultString(rule.App, "{dir}"), defaultString(rule.Log, "{name}"), rule.Service, path)
+		}
+	}
+
+	if dc := d.Docker; dc != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		containers, err := listDockerContainers(ctx, dc)
+		cancel()
+		if err != nil {
+			slog.Warn("docker discovery failed", "socket", dc.socket(), "error", err)
+		}
+		for _, c := range containers {
+			add(c.vars, defaultString(dc.App, "{name}"), defaultString(dc.Log, "stdout"), dc.Service, c.logPath)
+		}
+	}
+	return found
+}
+
+var templateVarRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// expandTemplate replaces {var} with its value; unknown variables expand
+// to nothing.
+func expandTemplate(tmpl string, vars map[string]string) string {
+	return templateVarRegex.ReplaceAllStringFunc(tmpl, func(m string) string {
+		return vars[m[1:len(m)-1]]
+	})
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func (dc *DockerDiscoveryConfig) socket() string {
+	return defaultString(dc.Socket, "/var/run/docker.sock")
+}
+
+type dockerContainer struct {
+	logPath string
+	vars    map[string]string
+}
+
+// listDockerContainers asks the Docker Engine API for running containers
+// with the label and their log file. Containers using a log driver other
+// than json-file have no LogPath and are skipped.
+func listDockerContainers(ctx context.Context, dc *DockerDiscoveryConfig) ([]dockerContainer, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", dc.socket())
+		},
+	}}
+
+	filters, _ := json.Marshal(map[string][]string{"label": {defaultString(dc.Label, "goagent.log=true")}})
+	var list []struct {
+		ID     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := dockerGet(ctx, client, "/containers/json?filters="+url.QueryEscape(string(filters)), &list); err != nil {
+		return nil, err
+	}
+
+	var out []dockerContainer
+	for _, c := range list {
+		var inspect struct {
+			LogPath string `json:"LogPath"`
+		}
+		if err := dockerGet(ctx, client, "/containers/"+c.ID+"/json", &inspect); err != nil {
+			return out, err
+		}
+		if inspect.LogPath == "" {
+			continue
+		}
+
+		vars := map[string]string{"id": c.ID[:min(12, len(c.ID))], "image": c.Image}
+		if len(c.Names) > 0 {
+			vars["name"] = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for k, v := range c.Labels {
+			vars["label."+k] = v
+		}
+		out = append(out, dockerContainer{logPath: inspect.LogPath, vars: vars})
+	}
+	return out, nil
+}
+
+func dockerGet(ctx context.Context, client *http.Client, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker API %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func validateDiscoveryConfig(d *DiscoveryConfig) []string {
+	var problems []string
+	if d.IntervalSeconds < 0 {
+		problems = append(problems, "discovery.interval_seconds: must not be negative")
+	}
+	for i, rule := range d.Files {
+		field := fmt.Sprintf("discovery.files[%d]", i)
+		if rule.Glob == "" {
+			problems = append(problems, field+".glob: required")
+		} else if _, err := filepath.Match(rule.Glob, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.glob: %v", field, err))
+		}
+		if rule.Match != "" {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.match: %v", field, err))
+			}
+		}
+	}
+	return problems
+}
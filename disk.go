@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//
+// ===================== DISK WATCHDOG =====================
+//
+
+// DiskConfig watches free space on the filesystems holding file targets. A
+// filesystem is flagged when its usage crosses a threshold, or when the
+// targets on it grow fast enough to fill it within ExhaustionHours.
+// Findings show up at /logs/disk, in the agent's own log and among the
+// /logs/analyze recommendations.
+type DiskConfig struct {
+	// IntervalSeconds is the sampling period (default 60).
+	IntervalSeconds int `yaml:"interval_seconds,omitempty"`
+	// WarnPercent and CriticalPercent are used-space thresholds
+	// (defaults 85 and 95).
+	WarnPercent     float64 `yaml:"warn_percent,omitempty"`
+	CriticalPercent float64 `yaml:"critical_percent,omitempty"`
+	// ExhaustionHours flags a filesystem whose log growth would fill it
+	// within this many hours (default 24).
+	ExhaustionHours float64 `yaml:"exhaustion_hours,omitempty"`
+}
+
+func (d *DiskConfig) interval() time.Duration {
+	if d.IntervalSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(d.IntervalSeconds) * time.Second
+}
+
+func (d *DiskConfig) warnPercent() float64 {
+	if d.WarnPercent <= 0 {
+		return 85
+	}
+	return d.WarnPercent
+}
+
+func (d *DiskConfig) criticalPercent() float64 {
+	if d.CriticalPercent <= 0 {
+		return 95
+	}
+	return d.CriticalPercent
+}
+
+func (d *DiskConfig) exhaustionHours() float64 {
+	if d.ExhaustionHours <= 0 {
+		return 24
+	}
+	return d.ExhaustionHours
+}
+
+// diskAlpha weighs each new interval in the growth rates.
+const diskAlpha = 0.2
+
+const (
+	diskOK       = "ok"
+	diskWarning  = "warning"
+	diskCritical = "critical"
+	diskFilling  = "filling"
+)
+
+// DiskStats is what /logs/disk reports for one filesystem.
+type DiskStats struct {
+	// Path is the directory of the first target found on the filesystem.
+	Path    string   `json:"path"`
+	Targets []string `json:"targets"`
+	// State is ok, warning, critical or filling.
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	TotalBytes  uint64    `json:"total_bytes"`
+	FreeBytes   uint64    `json:"free_bytes"`
+	UsedPercent float64   `json:"used_percent"`
+	// GrowthBytesPerHour is how fast the targets on the filesystem grow
+	// together, and HoursLeft when that fills the free space.
+	GrowthBytesPerHour float64  `json:"growth_bytes_per_hour"`
+	HoursLeft          *float64 `json:"hours_left,omitempty"`
+
+	// apps lists each target's app for visibility checks.
+	apps []string
+}
+
+// fileGrowth tracks how fast one file target grows.
+type fileGrowth struct {
+	size    int64
+	at      time.Time
+	rate    float64 // bytes per second
+	samples int
+}
+
+var (
+	diskMu     sync.Mutex
+	diskStats  = map[uint64]*DiskStats{}
+	diskGrowth = map[fanOutTarget]*fileGrowth{}
+)
+
+// startDiskWatchdog samples filesystems while a disk section is configured.
+func startDiskWatchdog() {
+	go func() {
+		for {
+			cfg := getConfig()
+			if cfg == nil || cfg.Disk == nil {
+				time.Sleep(time.Minute)
+				continue
+			}
+			sampleDisks(cfg, time.Now())
+			time.Sleep(cfg.Disk.interval())
+		}
+	}()
+}
+
+func sampleDisks(cfg *Config, now time.Time) {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+
+	current := map[uint64]*DiskStats{}
+	seen := map[fanOutTarget]bool{}
+	for _, t := range fanOutTargets(cfg, fanOutWildcard, fanOutWildcard) {
+		path, ok := targetLocalPath(cfg.Apps[t.app].Logs[t.key])
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		dev := uint64(st.Dev)
+
+		d, ok := current[dev]
+		if !ok {
+			var fs syscall.Statfs_t
+			if err := syscall.Statfs(path, &fs); err != nil {
+				slog.Warn("disk stat failed", "path", path, "error", err)
+				continue
+			}
+			bsize := uint64(fs.Bsize)
+			total, free := uint64(fs.Blocks)*bsize, uint64(fs.Bavail)*bsize
+			used := total - uint64(fs.Bfree)*bsize
+			d = &DiskStats{Path: filepath.Dir(path), TotalBytes: total, FreeBytes: free}
+			if used+free > 0 {
+				// Like df, leave the space reserved for root out.
+				d.UsedPercent = 100 * float64(used) / float64(used+free)
+			}
+			current[dev] = d
+		}
+		d.Targets = append(d.Targets, t.app+"/"+t.key)
+		d.apps = append(d.apps, t.app)
+
+		seen[t] = true
+		g := diskGrowth[t]
+		if g == nil {
+			g = &fileGrowth{}
+			diskGrowth[t] = g
+		}
+		g.observe(info.Size(), now)
+		d.GrowthBytesPerHour += g.rate * 3600
+	}
+	for t := range diskGrowth {
+		if !seen[t] {
+			delete(diskGrowth, t)
+		}
+	}
+
+	for dev, d := range current {
+		if d.GrowthBytesPerHour > 0 {
+			hours := float64(d.FreeBytes) / d.GrowthBytesPerHour
+			d.HoursLeft = &hours
+		}
+		d.State = cfg.Disk.state(d)
+		d.Since = now
+		if prev, ok := diskStats[dev]; ok && prev.State == d.State {
+			d.Since = prev.Since
+		} else {
+			d.logTransition(prev)
+		}
+	}
+	diskStats = current
+}
+
+// observe adds a size sample to the growth rate.
+func (g *fileGrowth) observe(size int64, now time.Time) {
+	if g.samples == 0 {
+		g.size, g.at, g.samples = size, now, 1
+		return
+	}
+	elapsed := now.Sub(g.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	written := size - g.size
+	if written < 0 {
+		// Truncated or rotated: everything in the new file is new.
+		written = size
+	}
+	rate := float64(written) / elapsed
+	if g.samples == 1 {
+		g.rate = rate
+	} else {
+		g.rate += diskAlpha * (rate - g.rate)
+	}
+	g.size, g.at = size, now
+	g.samples++
+}
+
+func (d *DiskConfig) state(s *DiskStats) string {
+	switch {
+	case s.UsedPercent >= d.criticalPercent():
+		return diskCritical
+	case s.HoursLeft != nil && *s.HoursLeft <= d.exhaustionHours():
+		return diskFilling
+	case s.UsedPercent >= d.warnPercent():
+		return diskWarning
+	}
+	return diskOK
+}
+
+func (d *DiskStats) logTransition(prev *DiskStats) {
+	switch d.State {
+	case diskCritical:
+		slog.Error("log disk almost full", "path", d.Path, "used_percent", math.Round(d.UsedPercent*10)/10, "free_bytes", d.FreeBytes)
+	case diskFilling:
+		slog.Warn("log disk filling up", "path", d.Path, "hours_left", math.Round(*d.HoursLeft*10)/10, "growth_bytes_per_hour", int64(d.GrowthBytesPerHour))
+	case diskWarning:
+		slog.Warn("log disk usage high", "path", d.Path, "used_percent", math.Round(d.UsedPercent*10)/10)
+	case diskOK:
+		if prev != nil {
+			slog.Info("log disk usage back to normal", "path", d.Path)
+		}
+	}
+}
+
+// diskSnapshot copies the stats of the filesystems holding targets the
+// caller may see, listing only those targets.
+func diskSnapshot(r *http.Request, cfg *Config, app string) []DiskStats {
+	diskMu.Lock()
+	out := make([]DiskStats, 0, len(diskStats))
+	for _, s := range diskStats {
+		c := *s
+		c.Targets, c.apps = nil, nil
+		for i, target := range s.Targets {
+			if app != "" && s.apps[i] != app {
+				continue
+			}
+			if cfg != nil && !appVisible(r.Context(), cfg, s.apps[i]) {
+				continue
+			}
+			c.Targets = append(c.Targets, target)
+		}
+		if len(c.Targets) > 0 {
+			out = append(out, c)
+		}
+	}
+	diskMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// diskHandler lists the watched filesystems, or those holding one app's
+// targets with app=.
+func diskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil || cfg.Disk == nil {
+		writeError(w, r, http.StatusNotFound, "disk watchdog is not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, diskSnapshot(r, cfg, r.URL.Query().Get("app")))
+}
+
+// diskRecommendations turns current disk findings into /logs/analyze
+// recommendations.
+func diskRecommendations(r *http.Request, cfg *Config) []map[string]string {
+	if cfg == nil || cfg.Disk == nil {
+		return nil
+	}
+
+	var recs []map[string]string
+	for _, s := range diskSnapshot(r, cfg, "") {
+		switch s.State {
+		case diskCritical:
+			recs = append(recs, map[string]string{
+				"title":       fmt.Sprintf("Log disk at %s is %.0f%% full", s.Path, s.UsedPercent),
+				"description": fmt.Sprintf("Only %d bytes left for %s. Rotate or compress logs, or free space before writes start failing.", s.FreeBytes, strings.Join(s.Targets, ", ")),
+				"severity":    "HIGH",
+			})
+		case diskFilling:
+			recs = append(recs, map[string]string{
+				"title":       fmt.Sprintf("Log disk at %s fills in %.1f hours", s.Path, *s.HoursLeft),
+				"description": fmt.Sprintf("Targets %s grow by about %.0f bytes per hour with %d bytes free; check rotation and look for an error loop.", strings.Join(s.Targets, ", "), s.GrowthBytesPerHour, s.FreeBytes),
+				"severity":    "HIGH",
+			})
+		case diskWarning:
+			recs = append(recs, map[string]string{
+				"title":       fmt.Sprintf("Log disk at %s is %.0f%% full", s.Path, s.UsedPercent),
+				"description": fmt.Sprintf("Usage is past %.0f%%; review retention for %s.", cfg.Disk.warnPercent(), strings.Join(s.Targets, ", ")),
+				"severity":    "MEDIUM",
+			})
+		}
+	}
+	return recs
+}
+
+func validateDiskConfig(d *DiskConfig) []string {
+	var problems []string
+	if d.IntervalSeconds < 0 {
+		problems = append(problems, "disk.interval_seconds: must not be negative")
+	}
+	if d.WarnPercent < 0 || d.WarnPercent > 100 {
+		problems = append(problems, "disk.warn_percent: must be between 0 and 100")
+	}
+	if d.CriticalPercent < 0 || d.CriticalPercent > 100 {
+		problems = append(problems, "disk.critical_percent: must be between 0 and 100")
+	}
+	if d.warnPercent() > d.criticalPercent() {
+		problems = append(problems, "disk.warn_percent: must not exceed critical_percent")
+	}
+	if d.ExhaustionHours < 0 {
+		problems = append(problems, "disk.exhaustion_hours: must not be negative")
+	}
+	return problems
+}
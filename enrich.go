@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+//
+// ===================== ENRICHMENT =====================
+//
+
+// EnrichmentConfig attaches labels to every line read from a configured
+// target, so consumers can filter by environment, region or team instead
+// of guessing from paths. Labels set on an app or log target win over
+// these, and a target's service becomes the service label.
+type EnrichmentConfig struct {
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Env maps label names to environment variables read on each request,
+	// e.g. region: AWS_REGION or k8s_node: NODE_NAME. Unset variables add
+	// no label.
+	Env map[string]string `yaml:"env,omitempty"`
+	// Hostname adds the agent's host name as the hostname label.
+	Hostname bool `yaml:"hostname,omitempty"`
+}
+
+var labelNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// targetLabels merges the labels for one target, lowest precedence first:
+// host facts, enrichment.labels, the target's service, then the app's and
+// the target's own labels. It returns nil when there are none.
+func (cfg *Config) targetLabels(app, key string) map[string]string {
+	if cfg == nil {
+		return nil
+	}
+	labels := map[string]string{}
+	if e := cfg.Enrichment; e != nil {
+		if e.Hostname {
+			if host, err := os.Hostname(); err == nil {
+				labels["hostname"] = host
+			}
+		}
+		for name, env := range e.Env {
+			if v := os.Getenv(env); v != "" {
+				labels[name] = v
+			}
+		}
+		for name, v := range e.Labels {
+			labels[name] = v
+		}
+		if service := cfg.Apps[app].Logs[key].Service; service != "" {
+			labels["service"] = service
+		}
+	}
+	for name, v := range cfg.Apps[app].Labels {
+		labels[name] = v
+	}
+	for name, v := range cfg.Apps[app].Logs[key].Labels {
+		labels[name] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// applyLabels sets labels on every formatted line of output. Pass-through
+// JSON payloads are left alone.
+func applyLabels(output interface{}, labels map[string]string) {
+	lines, ok := output.([]LogOutput)
+	if !ok || labels == nil {
+		return
+	}
+	for i := range lines {
+		lines[i].Labels = labels
+	}
+}
+
+func validateLabels(field string, labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		if !labelNameRegex.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("%s: invalid label name %q (letters, digits, _, . and -, not starting with a digit)", field, name))
+		}
+	}
+	return problems
+}
+
+func validateEnrichment(e *EnrichmentConfig) []string {
+	problems := validateLabels("enrichment.labels", e.Labels)
+	problems = append(problems, validateLabels("enrichment.env", e.Env)...)
+	for name, env := range e.Env {
+		if env == "" {
+			problems = append(problems, fmt.Sprintf("enrichment.env.%s: environment variable name required", name))
+		}
+	}
+	return problems
+}
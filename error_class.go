@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//
+// ===================== ERROR CLASSES =====================
+//
+
+// ErrorClassExtraction sorts ERROR and WARN lines into a taxonomy so a
+// read can say "mostly database errors" rather than listing lines. Rules
+// are tried in order before the built-in heuristics, which know the
+// network, database, auth, oom, dependency and config classes.
+type ErrorClassExtraction struct {
+	Rules []ErrorClassRule `yaml:"rules,omitempty"`
+	// DisableBuiltin leaves only Rules.
+	DisableBuiltin bool `yaml:"disable_builtin,omitempty"`
+}
+
+// ErrorClassRule puts lines matching Regex in Class.
+type ErrorClassRule struct {
+	Class string `yaml:"class"`
+	Regex string `yaml:"regex"`
+}
+
+// builtinErrorClasses are checked in order, so the more specific classes
+// come first: an OutOfMemoryError inside a JDBC call is oom, and a
+// connection refused by the database is database rather than network.
+var builtinErrorClasses = []ErrorClassRule{
+	{"oom", `(?i)OutOfMemoryError|OOMKilled|out of memory|cannot allocate memory|heap space`},
+	{"database", `(?i)SQLException|SQLSTATE|deadlock|HikariPool|connection pool|ORA-\d+|duplicate key|PSQLException|MongoError|MySQL|Postgres|Redis(?:Connection)?Exception`},
+	{"auth", `(?i)\bunauthori[sz]ed\b|\bforbidden\b|access denied|permission denied|invalid (?:token|credentials)|token expired|authentication failed|\b40[13]\b`},
+	{"config", `(?i)missing (?:required )?(?:config|property|setting|env)|invalid config|NoSuchBeanDefinition|BeanCreationException|could not resolve placeholder|not configured|environment variable .* not set`},
+	{"dependency", `(?i)circuit ?breaker|upstream|bad gateway|service unavailable|\b50[234]\b|dependency|remote service`},
+	{"network", `(?i)connection (?:refused|reset|timed out|closed)|ECONNREFUSED|ECONNRESET|ETIMEDOUT|no route to host|network is unreachable|broken pipe|UnknownHostException|SocketTimeoutException|i/o timeout|no such host|timed? ?out`},
+}
+
+func (cfg *Config) errorClassExtraction() *ErrorClassExtraction {
+	if cfg == nil || cfg.Extractors == nil {
+		return nil
+	}
+	return cfg.Extractors.ErrorClass
+}
+
+// applyTo sets ErrorClass on ERROR and WARN lines that match a rule.
+func (e *ErrorClassExtraction) applyTo(out *LogOutput) {
+	if out.Severity != "ERROR" && out.Severity != "WARN" {
+		return
+	}
+	rules := e.Rules
+	if !e.DisableBuiltin {
+		rules = append(rules[:len(rules):len(rules)], builtinErrorClasses...)
+	}
+	for _, rule := range rules {
+		re, err := extractRegex(rule.Regex)
+		if err == nil && re.MatchString(out.Raw) {
+			out.ErrorClass = rule.Class
+			return
+		}
+	}
+}
+
+// ErrorClassCounts tallies the error classes seen in one read.
+type ErrorClassCounts map[string]int
+
+// String lists the classes by count, e.g. "database=3, network=1".
+func (c ErrorClassCounts) String() string {
+	classes := make([]string, 0, len(c))
+	for class := range c {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if c[classes[i]] != c[classes[j]] {
+			return c[classes[i]] > c[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s=%d", class, c[class])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// errorClassCounts counts the error_class of formatted lines. It returns
+// nil when none carries one.
+func errorClassCounts(output interface{}) ErrorClassCounts {
+	lines, ok := output.([]LogOutput)
+	if !ok {
+		return nil
+	}
+	var c ErrorClassCounts
+	for _, l := range lines {
+		if l.ErrorClass == "" {
+			continue
+		}
+		if c == nil {
+			c = ErrorClassCounts{}
+		}
+		c[l.ErrorClass]++
+	}
+	return c
+}
+
+var errorClassNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+func validateErrorClassExtraction(e *ErrorClassExtraction) []string {
+	var problems []string
+	for i, rule := range e.Rules {
+		field := fmt.Sprintf("extractors.error_class.rules[%d]", i)
+		if !errorClassNameRegex.MatchString(rule.Class) {
+			problems = append(problems, fmt.Sprintf("%s.class: invalid class %q (lower-case letters, digits, _ and -)", field, rule.Class))
+		}
+		if rule.Regex == "" {
+			problems = append(problems, field+".regex: required")
+		} else if _, err := regexp.Compile(rule.Regex); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.regex: %v", field, err))
+		}
+	}
+	if e.DisableBuiltin && len(e.Rules) == 0 {
+		problems = append(problems, "extractors.error_class: disable_builtin leaves no rules")
+	}
+	return problems
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+//
+// ===================== ERROR RESPONSES =====================
+//
+
+// APIError is the body of every error response, so clients can branch on
+// code instead of parsing messages.
+type APIError struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusUnprocessableEntity:   "invalid_config",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusRequestEntityTooLarge: "too_large",
+}
+
+// writeError sends an APIError with the given status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string, details ...string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestIDFromContext(r.Context()),
+	})
+}
+
+// writeErrorFor maps err to a status with statusForError and sends it. The
+// individual findings of a ConfigProblems go into details.
+func writeErrorFor(w http.ResponseWriter, r *http.Request, err error) {
+	var problems ConfigProblems
+	if errors.As(err, &problems) {
+		writeError(w, r, http.StatusUnprocessableEntity, "config is invalid", problems...)
+		return
+	}
+	writeError(w, r, statusForError(err), err.Error())
+}
+
+// errNotFound reports a missing app, log target or other named resource.
+type errNotFound string
+
+func (e errNotFound) Error() string { return string(e) }
+
+// errInternal marks failures on the agent's side (disk, I/O) rather than
+// in the request.
+type errInternal struct{ error }
+
+func (e errInternal) Unwrap() error { return e.error }
+
+func statusForError(err error) int {
+	var (
+		notFound errNotFound
+		problems ConfigProblems
+		internal errInternal
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &problems):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &internal):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// ===================== ETAGS =====================
+//
+
+// etagEpoch keeps ETags from one run from matching the next, whose config
+// generations start over.
+var etagEpoch = time.Now().UnixNano()
+
+// logsETag returns a weak ETag for a /logs read, from everything the
+// response depends on: the source's change token, the query (format=
+// included), the negotiated content type, the active config and any level
+// override. Polling dashboards then get a 304 without the source being read. It returns "" for sources with no cheap change
+// check, and for an invalid time range, which the read reports.
+func logsETag(cfg *Config, src LogSource, r *http.Request) string {
+	pollable, ok := src.(pollableLogSource)
+	if !ok {
+		return ""
+	}
+	token, err := pollable.ChangeToken()
+	if err != nil {
+		return ""
+	}
+	var generation uint64
+	if cfg != nil {
+		generation = cfg.generation
+	}
+
+	// Relative times such as since=-15m select a different window on every
+	// request, so the query is hashed with them resolved, to the second.
+	q := r.URL.Query()
+	since, until, err := parseTimeRange(r)
+	if err != nil {
+		return ""
+	}
+	if !since.IsZero() {
+		q.Set("since", since.Truncate(time.Second).UTC().Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		q.Set("until", until.Truncate(time.Second).UTC().Format(time.RFC3339))
+	}
+
+	var override string
+	if o, ok := activeLevelOverride(q.Get("app"), q.Get("log"), time.Now()); ok {
+		override = o.MinLevel + "@" + o.Expires.String()
+	}
+
+	// JSON and MessagePack bodies differ, so they must not share a tag.
+	contentType := "application/json"
+	if wantsMsgpack(r) {
+		contentType = msgpackContentType
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d\x00%s", token, q.Encode(), contentType, etagEpoch, generation, override)
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
+
+// ChangeToken is the file's path, modification time and size.
+func (f *FileLogSource) ChangeToken() (string, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d", f.Path, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "*" || strings.TrimPrefix(part, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// ===================== FIELD EXTRACTION =====================
+//
+
+// ExtractorsConfig pulls numeric attributes out of log messages so they
+// show up on each LogOutput instead of having to be grepped for.
+type ExtractorsConfig struct {
+	Latency    *LatencyExtraction    `yaml:"latency,omitempty"`
+	HTTPStatus *HTTPStatusExtraction `yaml:"http_status,omitempty"`
+	AccessLog  *AccessLogExtraction  `yaml:"access_log,omitempty"`
+	ErrorClass *ErrorClassExtraction `yaml:"error_class,omitempty"`
+}
+
+// LatencyExtraction turns "took 12ms" or "duration=1.2s" into latency_ms.
+// Patterns are tried in order and the first match wins; without any, the
+// built-in took/duration/elapsed/latency forms are used.
+type LatencyExtraction struct {
+	Patterns []LatencyPattern `yaml:"patterns,omitempty"`
+}
+
+// LatencyPattern captures the number in a group named value (or the first
+// group). A group named unit overrides Unit per match; Unit is one of ns,
+// us, ms (default) or s.
+type LatencyPattern struct {
+	Regex string `yaml:"regex"`
+	Unit  string `yaml:"unit,omitempty"`
+}
+
+var defaultLatencyPatterns = []LatencyPattern{{
+	Regex: `(?i)\b(?:took|duration|elapsed|latency|response_time)[=:\s]+(?P<value>\d+(?:\.\d+)?)\s*(?P<unit>ns|us|µs|ms|s)\b`,
+}}
+
+var latencyUnitsMS = map[string]float64{
+	"ns": 1e-6,
+	"us": 1e-3,
+	"µs": 1e-3,
+	"ms": 1,
+	"s":  1000,
+	"":   1,
+}
+
+var extractRegexes sync.Map // pattern -> *regexp.Regexp
+
+func extractRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := extractRegexes.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	extractRegexes.Store(pattern, re)
+	return re, nil
+}
+
+// latencyExtraction returns cfg's latency settings, or nil when latency
+// extraction is off.
+func (cfg *Config) latencyExtraction() *LatencyExtraction {
+	if cfg == nil || cfg.Extractors == nil {
+		return nil
+	}
+	return cfg.Extractors.Latency
+}
+
+// extract returns the latency in milliseconds found in line, if any.
+func (l *LatencyExtraction) extract(line string) (float64, bool) {
+	patterns := l.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultLatencyPatterns
+	}
+
+	for _, p := range patterns {
+		re, err := extractRegex(p.Regex)
+		if err != nil {
+			continue
+		}
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		value, unit := "", strings.ToLower(p.Unit)
+		if len(m) > 1 {
+			value = m[1]
+		}
+		for i, name := range re.SubexpNames() {
+			switch {
+			case name == "value":
+				value = m[i]
+			case name == "unit" && m[i] != "":
+				unit = strings.ToLower(m[i])
+			}
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		scale, ok := latencyUnitsMS[unit]
+		if err != nil || !ok {
+			continue
+		}
+		return v * scale, true
+	}
+	return 0, false
+}
+
+// HTTPStatusExtraction finds response codes in access logs and app
+// messages. Each pattern captures the code in its first group; without
+// any, common access-log, status=NNN and "status":NNN forms are used.
+type HTTPStatusExtraction struct {
+	Patterns []string `yaml:"patterns,omitempty"`
+	// Escalate5xx reports 5xx lines as ERROR even when the line's own
+	// level is lower, so a burst of failing requests logged at INFO still
+	// stands out.
+	Escalate5xx bool `yaml:"escalate_5xx,omitempty"`
+}
+
+var defaultHTTPStatusPatterns = []string{
+	`"[A-Z]+ [^"]* HTTP/[\d.]+" (\d{3})\b`,
+	`(?i)\bstatus(?:_code)?"?\s*[=:]\s*"?(\d{3})\b`,
+}
+
+func (cfg *Config) httpStatusExtraction() *HTTPStatusExtraction {
+	if cfg == nil || cfg.Extractors == nil {
+		return nil
+	}
+	return cfg.Extractors.HTTPStatus
+}
+
+// extract returns the HTTP status code found in line, if any.
+func (h *HTTPStatusExtraction) extract(line string) (int, bool) {
+	patterns := h.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultHTTPStatusPatterns
+	}
+	for _, p := range patterns {
+		re, err := extractRegex(p)
+		if err != nil {
+			continue
+		}
+		m := re.FindStringSubmatch(line)
+		if len(m) < 2 {
+			continue
+		}
+		if code, err := strconv.Atoi(m[1]); err == nil && code >= 100 && code <= 599 {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// applyTo sets HTTPStatus on a formatted line and escalates 5xx severity
+// when configured.
+func (h *HTTPStatusExtraction) applyTo(out *LogOutput) {
+	code, ok := h.extract(out.Raw)
+	if !ok {
+		return
+	}
+	out.HTTPStatus = code
+	if h.Escalate5xx && code >= 500 && out.Severity != "ERROR" {
+		out.Severity = "ERROR"
+	}
+}
+
+// HTTPStatusCounts tallies the response classes seen in one read.
+type HTTPStatusCounts struct {
+	Class1xx int `json:"1xx,omitempty"`
+	Class2xx int `json:"2xx"`
+	Class3xx int `json:"3xx,omitempty"`
+	Class4xx int `json:"4xx"`
+	Class5xx int `json:"5xx"`
+}
+
+func (c *HTTPStatusCounts) String() string {
+	return fmt.Sprintf("2xx=%d, 3xx=%d, 4xx=%d, 5xx=%d", c.Class2xx, c.Class3xx, c.Class4xx, c.Class5xx)
+}
+
+// httpStatusCounts counts the http_status of formatted lines. It returns
+// nil when none carries one.
+func httpStatusCounts(output interface{}) *HTTPStatusCounts {
+	lines, ok := output.([]LogOutput)
+	if !ok {
+		return nil
+	}
+
+	var c HTTPStatusCounts
+	seen := false
+	for _, l := range lines {
+		switch l.HTTPStatus / 100 {
+		case 1:
+			c.Class1xx++
+		case 2:
+			c.Class2xx++
+		case 3:
+			c.Class3xx++
+		case 4:
+			c.Class4xx++
+		case 5:
+			c.Class5xx++
+		default:
+			continue
+		}
+		seen = true
+	}
+	if !seen {
+		return nil
+	}
+	return &c
+}
+
+// LatencyPercentiles summarizes the latencies found in one read.
+type LatencyPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+func (p *LatencyPercentiles) String() string {
+	return fmt.Sprintf("p50=%g, p95=%g, p99=%g, count=%d", p.P50, p.P95, p.P99, p.Count)
+}
+
+// latencyPercentiles computes nearest-rank percentiles over the latency_ms
+// of formatted lines. It returns nil for pass-through JSON payloads and
+// reads without any latency.
+func latencyPercentiles(output interface{}) *LatencyPercentiles {
+	lines, ok := output.([]LogOutput)
+	if !ok {
+		return nil
+	}
+
+	var values []float64
+	for _, l := range lines {
+		if l.LatencyMS != nil {
+			values = append(values, *l.LatencyMS)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(values)))) - 1
+		return values[max(i, 0)]
+	}
+	return &LatencyPercentiles{Count: len(values), P50: rank(50), P95: rank(95), P99: rank(99)}
+}
+
+func validateExtractors(e *ExtractorsConfig) []string {
+	var problems []string
+	if e.AccessLog != nil {
+		problems = append(problems, validateAccessLogExtraction(e.AccessLog)...)
+	}
+	if e.ErrorClass != nil {
+		problems = append(problems, validateErrorClassExtraction(e.ErrorClass)...)
+	}
+	if h := e.HTTPStatus; h != nil {
+		for i, p := range h.Patterns {
+			field := fmt.Sprintf("extractors.http_status.patterns[%d]", i)
+			if re, err := regexp.Compile(p); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", field, err))
+			} else if re.NumSubexp() == 0 {
+				problems = append(problems, field+": needs a capture group for the status code")
+			}
+		}
+	}
+	if e.Latency == nil {
+		return problems
+	}
+	for i, p := range e.Latency.Patterns {
+		field := fmt.Sprintf("extractors.latency.patterns[%d]", i)
+		re, err := regexp.Compile(p.Regex)
+		switch {
+		case p.Regex == "":
+			problems = append(problems, field+".regex: required")
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s.regex: %v", field, err))
+		case re.NumSubexp() == 0:
+			problems = append(problems, field+".regex: needs a capture group for the number")
+		}
+		if _, ok := latencyUnitsMS[strings.ToLower(p.Unit)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s.unit: invalid unit %q (expected ns, us, ms or s)", field, p.Unit))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// ===================== MULTI-TARGET READS =====================
+//
+
+// fanOutWildcard in app= or log= selects every matching configured target:
+// app=shop&log=* reads all of shop's logs, app=*&log=errors reads the
+// "errors" log of every app and app=*&log=* reads everything.
+const fanOutWildcard = "*"
+
+// FanOutConfig bounds multi-target reads.
+type FanOutConfig struct {
+	// Workers is how many targets are read at once (default 4).
+	Workers int `yaml:"workers,omitempty"`
+	// TimeoutSeconds caps each target's read (default 10).
+	TimeoutSeconds int `yaml:"timeout_seconds,omitempty"`
+}
+
+func (f *FanOutConfig) workers() int {
+	if f == nil || f.Workers <= 0 {
+		return 4
+	}
+	return f.Workers
+}
+
+func (f *FanOutConfig) timeout() time.Duration {
+	if f == nil || f.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(f.TimeoutSeconds) * time.Second
+}
+
+// FanOutResult is one target's part of a multi-target response. A failing
+// target reports Error instead of failing the whole request.
+type FanOutResult struct {
+	App       string              `json:"app"`
+	Log       string              `json:"log"`
+	Logs      interface{}         `json:"logs,omitempty"`
+	Truncated int                 `json:"truncated_lines,omitempty"`
+	Latency   *LatencyPercentiles `json:"latency_ms,omitempty"`
+	// HTTPStatus counts response classes for targets with
+	// extractors.http_status, e.g. to spot a 5xx surge in one service.
+	HTTPStatus *HTTPStatusCounts `json:"http_status,omitempty"`
+	// ErrorClasses counts the error classes of targets with
+	// extractors.error_class.
+	ErrorClasses ErrorClassCounts `json:"error_classes,omitempty"`
+	// ClockOffset is the correction applied to the target's timestamps.
+	ClockOffset string `json:"clock_offset,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type fanOutTarget struct {
+	app string
+	key string
+}
+
+// logsFanOutHandler serves /logs for wildcard app/log selections. Targets
+// are read concurrently by a bounded pool so one slow appliance only costs
+// its own timeout rather than delaying every other read.
+func logsFanOutHandler(w http.ResponseWriter, r *http.Request, appName, logKey string) {
+	cfg := getConfig()
+	if cfg == nil {
+		writeError(w, r, http.StatusBadRequest, "config not loaded; start server with -config flag")
+		return
+	}
+	if appName == "" || logKey == "" {
+		writeError(w, r, http.StatusBadRequest, "wildcard reads need both app and log")
+		return
+	}
+
+	since, until, err := parseTimeRange(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	targets := slices.DeleteFunc(fanOutTargets(cfg, appName, logKey), func(t fanOutTarget) bool {
+		return !appVisible(r.Context(), cfg, t.app)
+	})
+	if len(targets) == 0 {
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("no log targets match app=%s log=%s", appName, logKey))
+		return
+	}
+
+	var fanOut *FanOutConfig
+	if cfg.Server != nil {
+		fanOut = cfg.Server.FanOut
+	}
+
+	jobs := make(chan fanOutTarget)
+	results := make(chan FanOutResult)
+
+	var wg sync.WaitGroup
+	for range min(fanOut.workers(), len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				results <- readFanOutTarget(r, cfg, t, since, until, fanOut.timeout())
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, t := range targets {
+			select {
+			case jobs <- t:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	output := make([]FanOutResult, 0, len(targets))
+	for res := range results {
+		output = append(output, res)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].App != output[j].App {
+			return output[i].App < output[j].App
+		}
+		return output[i].Log < output[j].Log
+	})
+
+	writeNegotiated(w, r, http.StatusOK, output)
+}
+
+// fanOutTargets lists the configured targets matching a wildcard selection
+// in a stable order.
+func fanOutTargets(cfg *Config, appName, logKey string) []fanOutTarget {
+	var targets []fanOutTarget
+	for name, app := range cfg.Apps {
+		if appName != fanOutWildcard && name != appName {
+			continue
+		}
+		for key := range app.Logs {
+			if logKey != fanOutWildcard && key != logKey {
+				continue
+			}
+			targets = append(targets, fanOutTarget{app: name, key: key})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].app != targets[j].app {
+			return targets[i].app < targets[j].app
+		}
+		return targets[i].key < targets[j].key
+	})
+	return targets
+}
+
+func readFanOutTarget(r *http.Request, cfg *Config, t fanOutTarget, since, until time.Time, timeout time.Duration) FanOutResult {
+	res := FanOutResult{App: t.app, Log: t.key}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	logs, truncated, err := readTarget(ctx, cfg, t, parseLines(r, cfg.readSettings(t.app, t.key)), since, until)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Logs, res.Truncated = logs, truncated
+	res.Latency = latencyPercentiles(res.Logs)
+	res.HTTPStatus = httpStatusCounts(res.Logs)
+	res.ErrorClasses = errorClassCounts(res.Logs)
+	if offset := cfg.clockOffset(ctx, t.app, t.key); offset != 0 {
+		res.ClockOffset = offset.String()
+	}
+	return res
+}
+
+// readTarget reads the last lines of a configured target and formats them
+// as /logs would, holding one of the target's read slots meanwhile.
+func readTarget(ctx context.Context, cfg *Config, t fanOutTarget, lines int, since, until time.Time) (interface{}, int, error) {
+	src, err := sourceFromConfig(cfg, t.app, t.key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if cfg.Server != nil && cfg.Server.RateLimit != nil {
+		release, ok := acquireReadSlot("app:"+t.app+"/"+t.key, cfg.Server.RateLimit.MaxConcurrentReads)
+		if !ok {
+			return nil, 0, fmt.Errorf("too many concurrent reads of this target")
+		}
+		defer release()
+	}
+
+	settings := cfg.readSettings(t.app, t.key)
+	since, until = targetRange(since, until, cfg.clockOffset(ctx, t.app, t.key))
+	rawLogs, err := readLogSource(ctx, src, lines, since, until)
+	if err != nil {
+		slog.WarnContext(ctx, "log read failed", "target", "app:"+t.app+"/"+t.key, "error", err)
+		return nil, 0, fmt.Errorf("failed to read logs: %v", err)
+	}
+	target := cfg.Apps[t.app].Logs[t.key]
+	clean := redactLogs(cfg, target, sanitizeBinary([]byte(rawLogs)))
+	logs, truncated := formatLogOutput(cfg, clean, settings.MaxLineBytes)
+	recordUnparsed(cfg, t.app, t.key, logs)
+	logs, _ = filterLevels(logs, t.app, t.key)
+	applyLabels(logs, cfg.targetLabels(t.app, t.key))
+	return logs, truncated, nil
+}
+
+func validateFanOutConfig(f *FanOutConfig) []string {
+	var problems []string
+	if f.Workers < 0 {
+		problems = append(problems, "server.fan_out.workers: must not be negative")
+	}
+	if f.TimeoutSeconds < 0 {
+		problems = append(problems, "server.fan_out.timeout_seconds: must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+//
+// ===================== FILE TAIL READS =====================
+//
+
+// tailBlockSize is how much of the file is read per step when scanning
+// backwards for line breaks.
+const tailBlockSize = 64 << 10
+
+type textEncoding int
+
+const (
+	encodingUTF8 textEncoding = iota
+	encodingUTF16LE
+	encodingUTF16BE
+)
+
+// detectEncoding sniffs the byte order mark and returns the encoding plus
+// the BOM length to skip. Files without a BOM are treated as UTF-8.
+func detectEncoding(f *os.File) (textEncoding, int64, error) {
+	bom := make([]byte, 3)
+	n, err := f.ReadAt(bom, 0)
+	if err != nil && err != io.EOF {
+		return encodingUTF8, 0, err
+	}
+	bom = bom[:n]
+
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingUTF8, 3, nil
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		return encodingUTF16LE, 2, nil
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		return encodingUTF16BE, 2, nil
+	}
+	return encodingUTF8, 0, nil
+}
+
+func (e textEncoding) unitSize() int64 {
+	if e == encodingUTF8 {
+		return 1
+	}
+	return 2
+}
+
+func (e textEncoding) isNewline(b []byte) bool {
+	switch e {
+	case encodingUTF16LE:
+		return b[0] == '\n' && b[1] == 0
+	case encodingUTF16BE:
+		return b[0] == 0 && b[1] == '\n'
+	}
+	return b[0] == '\n'
+}
+
+// tailFileLines returns the last n lines of path (all lines if n <= 0),
+// newline-terminated. It scans backwards from the end in fixed-size blocks
+// and then reads at most maxLine bytes of each line, so memory is bounded by
+// n*maxLine no matter how large the file or its lines are. Longer lines are
+// cut and marked with truncationMarker. With maxRead > 0 only the last
+// maxRead bytes are scanned, so fewer than n lines may come back.
+func tailFileLines(ctx context.Context, path string, n, maxLine int, maxRead int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+
+	enc, start, err := detectEncoding(f)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+
+	unit := enc.unitSize()
+	end := start + (info.Size()-start)/unit*unit
+	if end <= start {
+		return "", nil
+	}
+
+	// A newline at the very end terminates the last line; it doesn't start
+	// an extra empty one.
+	last := make([]byte, unit)
+	if _, err := f.ReadAt(last, end-unit); err != nil && err != io.EOF {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	if enc.isNewline(last) {
+		end -= unit
+	}
+
+	scanFrom := start
+	if maxRead > 0 && end-start > maxRead {
+		scanFrom = end - maxRead/unit*unit
+	}
+	breaks, err := tailLineBreaks(ctx, f, enc, scanFrom, end, n)
+	if err != nil {
+		return "", err
+	}
+
+	lineStart := start
+	if (n > 0 && len(breaks) == n) || scanFrom > start {
+		// Either the break before the first wanted line, or the end of a
+		// line that began before the scanned window; drop it either way.
+		if len(breaks) == 0 {
+			return "", nil
+		}
+		lineStart = breaks[0] + unit
+		breaks = breaks[1:]
+	}
+
+	var b strings.Builder
+	for _, lineEnd := range append(breaks, end) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		size := lineEnd - lineStart
+		readSize := size
+		if maxLine > 0 && readSize > int64(maxLine) {
+			readSize = int64(maxLine) / unit * unit
+		}
+		data := make([]byte, readSize)
+		if _, err := f.ReadAt(data, lineStart); err != nil && err != io.EOF {
+			return "", fmt.Errorf("read file: %w", err)
+		}
+
+		line := strings.TrimSuffix(decodeText(enc, data), "\r")
+		if readSize < size {
+			line = truncateMarked(line, size)
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+
+		lineStart = lineEnd + unit
+	}
+	return b.String(), nil
+}
+
+// tailLineBreaks returns, in file order, the offsets of the newlines that
+// separate the last n lines of [start, end) (every newline if n <= 0). When
+// the range holds more than n lines, the first offset is the newline just
+// before the first of those lines.
+func tailLineBreaks(ctx context.Context, f *os.File, enc textEncoding, start, end int64, n int) ([]int64, error) {
+	unit := enc.unitSize()
+
+	var breaks []int64
+	buf := make([]byte, tailBlockSize)
+	pos := end
+	for pos > start {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		size := min(int64(tailBlockSize), pos-start)
+		pos -= size
+		block := buf[:size]
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+
+		for i := size - unit; i >= 0; i -= unit {
+			if enc.isNewline(block[i : i+unit]) {
+				breaks = append(breaks, pos+i)
+				if n > 0 && len(breaks) == n {
+					slices.Reverse(breaks)
+					return breaks, nil
+				}
+			}
+		}
+	}
+	slices.Reverse(breaks)
+	return breaks, nil
+}
+
+func decodeText(enc textEncoding, data []byte) string {
+	if enc == encodingUTF8 {
+		return string(data)
+	}
+
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		hi, lo := data[2*i+1], data[2*i]
+		if enc == encodingUTF16BE {
+			hi, lo = lo, hi
+		}
+		units[i] = uint16(hi)<<8 | uint16(lo)
+	}
+	return string(utf16.Decode(units))
+}
+
+// truncationMarker is appended to lines cut at max_line_bytes. It is plain
+// ASCII so it survives sanitizeBinary.
+const truncationMarker = " ...[truncated %d bytes]"
+
+var truncationMarkerRegex = regexp.MustCompile(` \.\.\.\[truncated \d+ bytes\]$`)
+
+func truncateMarked(line string, originalSize int64) string {
+	return line + fmt.Sprintf(truncationMarker, originalSize)
+}
+
+func isTruncated(line string) bool {
+	return truncationMarkerRegex.MatchString(line)
+}
+
+// splitLogLines splits text into lines, cutting any line longer than
+// maxLine bytes (0 means unlimited). It replaces bufio.Scanner, which stops
+// silently at its 64 KiB token limit.
+func splitLogLines(text string, maxLine int) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if maxLine > 0 && len(line) > maxLine && !isTruncated(line) {
+			line = truncateMarked(line[:maxLine], int64(len(line)))
+		}
+		lines[i] = line
+	}
+	return lines
+}
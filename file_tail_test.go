@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16File encodes s as UTF-16 with a byte order mark.
+func utf16File(s string, bigEndian bool) []byte {
+	out := []byte{0xFF, 0xFE}
+	if bigEndian {
+		out = []byte{0xFE, 0xFF}
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
+
+func TestTailFileLines(t *testing.T) {
+	long := strings.Repeat("x", tailBlockSize+100)
+	var many strings.Builder
+	for i := 0; i < 5000; i++ {
+		many.WriteString("line with some padding to cross block boundaries\n")
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		n       int
+		maxLine int
+		maxRead int64
+		want    string
+	}{
+		{"last lines", []byte("a\nb\nc\n"), 2, 0, 0, "b\nc\n"},
+		{"all lines", []byte("a\nb\nc\n"), 0, 0, 0, "a\nb\nc\n"},
+		{"more wanted than present", []byte("a\nb\n"), 10, 0, 0, "a\nb\n"},
+		{"no trailing newline", []byte("a\nb"), 1, 0, 0, "b\n"},
+		{"empty file", nil, 5, 0, 0, ""},
+		{"crlf", []byte("a\r\nb\r\nc\r\n"), 2, 0, 0, "b\nc\n"},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFfirst\nsecond\n"), 0, 0, 0, "first\nsecond\n"},
+		{"utf-16le bom", utf16File("héllo\r\nwörld\r\n", false), 2, 0, 0, "héllo\nwörld\n"},
+		{"utf-16be bom", utf16File("one\ntwo\n", true), 1, 0, 0, "two\n"},
+		{"truncation marker", []byte("short\nabcdefghij\n"), 2, 5, 0, "short\nabcde ...[truncated 10 bytes]\n"},
+		{"line longer than a block", []byte("first\n" + long + "\nlast\n"), 2, 10, 0, "xxxxxxxxxx ...[truncated 65636 bytes]\nlast\n"},
+		{"across blocks", []byte(many.String()), 3, 0, 0, strings.Repeat("line with some padding to cross block boundaries\n", 3)},
+		{"max read drops the partial first line", []byte("aaaa\nbbbb\ncccc\n"), 0, 0, 7, "cccc\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tailFileLines(context.Background(), path, tt.n, tt.maxLine, tt.maxRead)
+			if err != nil {
+				t.Fatalf("tailFileLines() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("tailFileLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// ===================== FLEET (AGGREGATOR MODE) =====================
+//
+
+// FleetConfig lists downstream agents this one can query on a caller's
+// behalf, so a single endpoint covers every host.
+type FleetConfig struct {
+	Agents []FleetAgent `yaml:"agents"`
+}
+
+// FleetAgent is one downstream agent. Its http section carries the key the
+// aggregator presents, e.g. bearer_token: ${AGENT_KEY}.
+type FleetAgent struct {
+	Name string            `yaml:"name"`
+	URL  string            `yaml:"url"`
+	HTTP *HTTPClientConfig `yaml:"http,omitempty"`
+}
+
+// FleetResult is one agent's answer to a /fleet/logs query. Logs is the
+// agent's /logs response body, passed through unchanged.
+type FleetResult struct {
+	Agent string          `json:"agent"`
+	Logs  json.RawMessage `json:"logs,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// fleetLogsHandler forwards a /logs query to the selected downstream agents
+// (agent=<name>, or agent=* / no agent for all of them) and returns their
+// answers side by side. A failing agent reports Error instead of failing
+// the whole request.
+func fleetLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil || cfg.Fleet == nil || len(cfg.Fleet.Agents) == 0 {
+		writeError(w, r, http.StatusNotFound, "no fleet agents are configured")
+		return
+	}
+
+	q := r.URL.Query()
+	name := q.Get("agent")
+	q.Del("agent")
+
+	var agents []FleetAgent
+	for _, a := range cfg.Fleet.Agents {
+		if name == "" || name == fanOutWildcard || a.Name == name {
+			agents = append(agents, a)
+		}
+	}
+	if len(agents) == 0 {
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown agent %q", name))
+		return
+	}
+
+	var fanOut *FanOutConfig
+	if cfg.Server != nil {
+		fanOut = cfg.Server.FanOut
+	}
+
+	output := make([]FleetResult, len(agents))
+	var wg sync.WaitGroup
+	for i, a := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), fanOut.timeout())
+			defer cancel()
+			output[i] = queryFleetAgent(ctx, a, q.Encode())
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(output, func(i, j int) bool { return output[i].Agent < output[j].Agent })
+	writeJSON(w, http.StatusOK, output)
+}
+
+func queryFleetAgent(ctx context.Context, a FleetAgent, query string) FleetResult {
+	res := FleetResult{Agent: a.Name}
+
+	client, err := a.HTTP.client()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	src := &APILogSource{
+		URL:     strings.TrimSuffix(a.URL, "/") + "/logs?" + query,
+		Client:  client,
+		Headers: a.HTTP.requestHeaders(),
+		Retry:   a.HTTP.retryPolicy(),
+	}
+
+	body, err := src.ReadLogs(ctx, 0)
+	if err != nil {
+		slog.WarnContext(ctx, "fleet agent query failed", "agent", a.Name, "error", err)
+		res.Error = err.Error()
+		return res
+	}
+	if json.Valid([]byte(body)) {
+		res.Logs = json.RawMessage(body)
+	} else {
+		res.Logs, _ = json.Marshal(body)
+	}
+	return res
+}
+
+func validateFleetConfig(f *FleetConfig) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for i, a := range f.Agents {
+		field := fmt.Sprintf("fleet.agents[%d]", i)
+		if a.Name == "" {
+			problems = append(problems, field+": missing name")
+		} else if seen[a.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate agent name %q", field, a.Name))
+		} else if a.Name == fanOutWildcard {
+			problems = append(problems, fmt.Sprintf("%s: name %q is reserved", field, a.Name))
+		}
+		seen[a.Name] = true
+
+		if a.URL == "" {
+			problems = append(problems, field+".url: required")
+		} else if err := checkHTTPURL(a.URL); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.url: %v", field, err))
+		}
+		if a.HTTP != nil {
+			problems = append(problems, validateHTTPClientConfig(field+".http", a.HTTP)...)
+		}
+	}
+	return problems
+}
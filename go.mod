@@ -2,7 +2,6 @@ module opscure.com/extension_agent
 
 go 1.25.5
 
-require (
-	github.com/sergi/go-diff v1.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-)
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/sergi/go-diff v1.4.0 // indirect
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+//
+// ===================== IP ALLOWLIST =====================
+//
+
+// AllowedCIDRs restricts which client networks may call the agent. Read and
+// action endpoints have separate lists. Action endpoints fall back to the
+// read list when theirs is empty, so a read allowlist never leaves actions
+// more open than reads; only with both lists empty is nothing restricted.
+// Entries are CIDRs or bare IPs. The peer address of the
+// connection is used; X-Forwarded-For is deliberately not trusted.
+type AllowedCIDRs struct {
+	Read   []string `yaml:"read,omitempty"`
+	Action []string `yaml:"action,omitempty"`
+}
+
+func ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		if cfg == nil || cfg.Server == nil || cfg.Server.AllowedCIDRs == nil || r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		list := cfg.Server.AllowedCIDRs.Read
+		if isActionRequest(r) && len(cfg.Server.AllowedCIDRs.Action) > 0 {
+			list = cfg.Server.AllowedCIDRs.Action
+		}
+		if len(list) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, err := netip.ParseAddr(remoteIP(r))
+		if err != nil || !addrAllowed(list, addr.Unmap()) {
+			writeError(w, r, http.StatusForbidden, "client address not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func addrAllowed(list []string, addr netip.Addr) bool {
+	for _, entry := range list {
+		prefix, err := parseCIDR(entry)
+		if err != nil {
+			continue
+		}
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDR(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		p, err := netip.ParsePrefix(entry)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func validateAllowedCIDRs(a *AllowedCIDRs) []string {
+	var problems []string
+	for _, list := range []struct {
+		field   string
+		entries []string
+	}{
+		{"server.allowed_cidrs.read", a.Read},
+		{"server.allowed_cidrs.action", a.Action},
+	} {
+		for _, entry := range list.entries {
+			if _, err := parseCIDR(entry); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid CIDR or IP %q", list.field, entry))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAddrAllowed(t *testing.T) {
+	list := []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32", "not-an-ip", "172.16.5.9/12"}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"::ffff:10.9.9.9", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		// The host bits of 172.16.5.9/12 are masked off.
+		{"172.31.0.1", true},
+		{"172.32.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			addr := netip.MustParseAddr(tt.addr).Unmap()
+			if got := addrAllowed(list, addr); got != tt.want {
+				t.Fatalf("addrAllowed(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{"10.1.2.3/8", "10.0.0.0/8", false},
+		{"192.168.1.7", "192.168.1.7/32", false},
+		{"::ffff:192.168.1.7", "192.168.1.7/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"10.0.0.0/33", "", true},
+		{"example.com", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, err := parseCIDR(tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseCIDR(%q) = %v, want an error", tt.entry, got)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Fatalf("parseCIDR(%q) = %v, %v, want %s", tt.entry, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+//
+// ===================== JWT / OIDC AUTH =====================
+//
+
+// JWTConfig validates bearer JWTs issued by an OIDC provider. Keys come from
+// JWKSURL, or from the issuer's discovery document when JWKSURL is empty.
+type JWTConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience,omitempty"`
+	JWKSURL  string `yaml:"jwks_url,omitempty"`
+	// RolesClaim is a dot path to the roles in the token, e.g. "roles" or
+	// "realm_access.roles". Space-separated strings (like "scope") work too.
+	RolesClaim string `yaml:"roles_claim,omitempty"`
+	// ReadRoles grant read access; empty means any valid token may read.
+	ReadRoles []string `yaml:"read_roles,omitempty"`
+	// ActionRoles grant action access (apply-patch, config changes).
+	ActionRoles   []string `yaml:"action_roles,omitempty"`
+	LeewaySeconds int      `yaml:"leeway_seconds,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyJWT checks the signature and standard claims of token and returns the
+// resulting principal.
+func verifyJWT(ctx context.Context, c *JWTConfig, token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("header: %w", err)
+	}
+
+	key, err := jwksKeyFor(ctx, c, header.Kid)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("signature: %w", err)
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Principal{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("claims: %w", err)
+	}
+	if err := checkJWTClaims(c, claims, time.Now()); err != nil {
+		return Principal{}, err
+	}
+
+	roles := claimStrings(claims, c.RolesClaim)
+	scope := ""
+	switch {
+	case hasAnyRole(roles, c.ActionRoles):
+		scope = scopeAction
+	case len(c.ReadRoles) == 0 || hasAnyRole(roles, c.ReadRoles):
+		scope = scopeRead
+	default:
+		return Principal{}, fmt.Errorf("token has none of the roles allowed to access the agent")
+	}
+
+	name, _ := claims["sub"].(string)
+	if preferred, ok := claims["preferred_username"].(string); ok && preferred != "" {
+		name = preferred
+	}
+	return Principal{Name: name, Scope: scope, Roles: roles}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		if err := rsa.VerifyPSS(pub, hash, digest, sig, nil); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		// Notably rejects "none" and HMAC algs, which would let anyone who
+		// knows the public key forge tokens.
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	return nil
+}
+
+func checkJWTClaims(c *JWTConfig, claims map[string]interface{}, now time.Time) error {
+	leeway := time.Duration(c.LeewaySeconds) * time.Second
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != c.Issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+
+	if c.Audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == c.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == c.Audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("token not issued for audience %q", c.Audience)
+		}
+	}
+	return nil
+}
+
+// claimStrings reads a list of strings at a dot path in claims.
+func claimStrings(claims map[string]interface{}, path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	var cur interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func hasAnyRole(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ===================== JWKS CACHE =====================
+
+const (
+	jwksRefreshInterval = 15 * time.Minute
+	// jwksMinRefetch limits refetches triggered by unknown kids, so garbage
+	// tokens can't turn the agent into a load generator against the IdP.
+	jwksMinRefetch = 30 * time.Second
+	// jwksFetchTimeout bounds one fetch, which runs detached from the
+	// request that triggered it.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jwksCache struct {
+	mu          sync.Mutex
+	url         string
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// fetching is closed when the fetch in flight ends; nil when none is.
+	fetching chan struct{}
+}
+
+var (
+	jwksMu     sync.Mutex
+	jwksCaches = map[string]*jwksCache{}
+	jwksClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func jwksKeyFor(ctx context.Context, c *JWTConfig, kid string) (crypto.PublicKey, error) {
+	jwksMu.Lock()
+	cache, ok := jwksCaches[c.Issuer+"|"+c.JWKSURL]
+	if !ok {
+		cache = &jwksCache{}
+		jwksCaches[c.Issuer+"|"+c.JWKSURL] = cache
+	}
+	jwksMu.Unlock()
+
+	cache.mu.Lock()
+	key, ok := cache.keys[kid]
+	stale := time.Since(cache.fetchedAt) > jwksRefreshInterval
+	if ok && !stale {
+		cache.mu.Unlock()
+		return key, nil
+	}
+	done := cache.fetching
+	if done == nil {
+		if time.Since(cache.lastAttempt) < jwksMinRefetch {
+			cache.mu.Unlock()
+			if ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// One fetch serves every request waiting for it, and a client
+		// hanging up does not cancel it for the others.
+		done = make(chan struct{})
+		cache.fetching = done
+		go cache.refresh(context.WithoutCancel(ctx), c, done)
+	}
+	cache.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if key, ok := cache.keys[kid]; ok {
+		return key, nil
+	}
+	if cache.lastErr != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", cache.lastErr)
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// refresh fetches the key set and closes done. A failed fetch keeps the
+// previous keys.
+func (j *jwksCache) refresh(ctx context.Context, c *JWTConfig, done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+
+	j.mu.Lock()
+	url := j.url
+	j.mu.Unlock()
+	url, keys, err := fetchJWKS(ctx, c, url)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fetching = nil
+	j.lastAttempt = time.Now()
+	j.lastErr = err
+	if err == nil {
+		j.url, j.keys, j.fetchedAt = url, keys, time.Now()
+	}
+}
+
+// fetchJWKS reads the key set at url, first finding url through the
+// issuer's discovery document when neither it nor jwks_url is known.
+func fetchJWKS(ctx context.Context, c *JWTConfig, url string) (string, map[string]crypto.PublicKey, error) {
+	if url == "" {
+		url = c.JWKSURL
+	}
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		wellKnown := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := getJSON(ctx, wellKnown, &discovery); err != nil {
+			return "", nil, fmt.Errorf("discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return "", nil, fmt.Errorf("discovery document has no jwks_uri")
+		}
+		url = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, url, &set); err != nil {
+		return "", nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	return url, keys, nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func validateJWTConfig(c *JWTConfig) []string {
+	var problems []string
+	if c.Issuer == "" {
+		problems = append(problems, "auth.jwt.issuer: required")
+	} else if err := checkHTTPURL(c.Issuer); err != nil {
+		problems = append(problems, fmt.Sprintf("auth.jwt.issuer: %v", err))
+	}
+	if c.JWKSURL != "" {
+		if err := checkHTTPURL(c.JWKSURL); err != nil {
+			problems = append(problems, fmt.Sprintf("auth.jwt.jwks_url: %v", err))
+		}
+	}
+	if len(c.ActionRoles) > 0 && c.RolesClaim == "" {
+		problems = append(problems, "auth.jwt.roles_claim: required when action_roles is set")
+	}
+	if c.LeewaySeconds < 0 {
+		problems = append(problems, "auth.jwt.leeway_seconds: must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer serves a JWKS with one P-256 key and signs tokens with it.
+type testIssuer struct {
+	key *ecdsa.PrivateKey
+	srv *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"k1","use":"sig","crv":"P-256","x":%q,"y":%q}]}`,
+		enc.EncodeToString(key.X.FillBytes(make([]byte, 32))), enc.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(jwks))
+	}))
+	t.Cleanup(srv.Close)
+	return &testIssuer{key: key, srv: srv}
+}
+
+// token signs claims with ES256, or leaves the signature empty for any
+// other alg.
+func (iss *testIssuer) token(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	if alg != "ES256" {
+		return signed + "."
+	}
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, iss.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := &JWTConfig{Issuer: "https://idp.example", Audience: "agent", JWKSURL: iss.srv.URL, RolesClaim: "roles", ActionRoles: []string{"ops"}}
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": cfg.Issuer, "aud": "agent", "sub": "alice", "exp": now + 60}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	// A validly signed token whose claims were swapped afterwards.
+	parts := strings.Split(iss.token(t, "ES256", claims(nil)), ".")
+	forged := strings.Split(iss.token(t, "none", claims(map[string]interface{}{"sub": "mallory"})), ".")
+	tampered := parts[0] + "." + forged[1] + "." + parts[2]
+
+	tests := []struct {
+		name      string
+		token     string
+		wantScope string
+		wantErr   string
+	}{
+		{"valid read", iss.token(t, "ES256", claims(nil)), scopeRead, ""},
+		{"valid action", iss.token(t, "ES256", claims(map[string]interface{}{"roles": []string{"ops"}})), scopeAction, ""},
+		{"alg none", iss.token(t, "none", claims(nil)), "", "unsupported alg"},
+		{"alg HS256", iss.token(t, "HS256", claims(nil)), "", "unsupported alg"},
+		{"tampered claims", tampered, "", "invalid signature"},
+		{"expired", iss.token(t, "ES256", claims(map[string]interface{}{"exp": now - 60})), "", "token expired"},
+		{"wrong audience", iss.token(t, "ES256", claims(map[string]interface{}{"aud": "other"})), "", "audience"},
+		{"malformed", "abc.def", "", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := verifyJWT(context.Background(), cfg, tt.token)
+			if tt.wantScope == "" {
+				if err == nil {
+					t.Fatalf("verifyJWT() = %+v, want an error", p)
+				}
+				if tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyJWT() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT() error = %v", err)
+			}
+			if p.Name != "alice" || p.Scope != tt.wantScope {
+				t.Fatalf("verifyJWT() = %+v, want alice with scope %s", p, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestCheckJWTClaims(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	unix := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+	cfg := &JWTConfig{Issuer: "iss", Audience: "agent", LeewaySeconds: 30}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr string
+	}{
+		{"valid", map[string]interface{}{"iss": "iss", "aud": "agent", "exp": unix(time.Minute)}, ""},
+		{"no exp", map[string]interface{}{"iss": "iss", "aud": "agent"}, "no exp"},
+		{"expired", map[string]interface{}{"iss": "iss", "aud": "agent", "exp": unix(-time.Minute)}, "expired"},
+		{"expired within leeway", map[string]interface{}{"iss": "iss", "aud": "agent", "exp": unix(-10 * time.Second)}, ""},
+		{"nbf in future", map[string]interface{}{"iss": "iss", "aud": "agent", "exp": unix(time.Hour), "nbf": unix(time.Minute)}, "not valid yet"},
+		{"nbf within leeway", map[string]interface{}{"iss": "iss", "aud": "agent", "exp": unix(time.Hour), "nbf": unix(10 * time.Second)}, ""},
+		{"wrong issuer", map[string]interface{}{"iss": "other", "aud": "agent", "exp": unix(time.Minute)}, "issuer"},
+		{"audience list", map[string]interface{}{"iss": "iss", "aud": []interface{}{"x", "agent"}, "exp": unix(time.Minute)}, ""},
+		{"missing audience", map[string]interface{}{"iss": "iss", "exp": unix(time.Minute)}, "audience"},
+		{"wrong audience", map[string]interface{}{"iss": "iss", "aud": []interface{}{"x"}, "exp": unix(time.Minute)}, "audience"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJWTClaims(cfg, tt.claims, now)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("checkJWTClaims() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("checkJWTClaims() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
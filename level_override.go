@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// ===================== LEVEL OVERRIDES =====================
+//
+
+const (
+	// defaultLevelOverrideTTL is how long an override lasts without
+	// ttl_seconds.
+	defaultLevelOverrideTTL = 30 * time.Minute
+	// maxLevelOverrideTTL keeps a forgotten override from hiding lines
+	// for days.
+	maxLevelOverrideTTL = 24 * time.Hour
+)
+
+// levelRanks orders the normalized severities.
+var levelRanks = map[string]int{"DEBUG": 1, "INFO": 2, "WARN": 3, "ERROR": 4}
+
+// LevelOverride raises the minimum level served for one target until it
+// expires, e.g. to drop DEBUG and INFO during a log storm without editing
+// the config. It lives in memory only and is gone after a restart.
+type LevelOverride struct {
+	App      string `json:"app"`
+	Log      string `json:"log"`
+	MinLevel string `json:"min_level"`
+	// TTLSeconds is how long a new override lasts (default 1800, at
+	// most 86400); Expires is when it ends.
+	TTLSeconds int       `json:"ttl_seconds,omitempty"`
+	Expires    time.Time `json:"expires"`
+}
+
+var (
+	levelOverridesMu sync.Mutex
+	levelOverrides   = map[fanOutTarget]LevelOverride{}
+)
+
+// activeLevelOverride returns the target's override, dropping it once it
+// has expired.
+func activeLevelOverride(app, key string, now time.Time) (LevelOverride, bool) {
+	levelOverridesMu.Lock()
+	defer levelOverridesMu.Unlock()
+
+	t := fanOutTarget{app: app, key: key}
+	o, ok := levelOverrides[t]
+	if ok && !now.Before(o.Expires) {
+		delete(levelOverrides, t)
+		slog.Info("level override expired", "app", app, "log", key, "min_level", o.MinLevel)
+		return o, false
+	}
+	return o, ok
+}
+
+// filterLevels drops the lines of output below the target's override and
+// reports how many went. Lines without a level, such as stack frames,
+// follow the line above them.
+func filterLevels(output interface{}, app, key string) (interface{}, int) {
+	lines, ok := output.([]LogOutput)
+	if !ok {
+		return output, 0
+	}
+	o, ok := activeLevelOverride(app, key, time.Now())
+	if !ok {
+		return output, 0
+	}
+
+	minRank := levelRanks[o.MinLevel]
+	kept := lines[:0]
+	dropping := false
+	for _, l := range lines {
+		if rank, ok := levelRanks[normalizeLevel(l.Severity)]; ok {
+			dropping = rank < minRank
+		}
+		if !dropping {
+			kept = append(kept, l)
+		}
+	}
+	return kept, len(lines) - len(kept)
+}
+
+// levelOverridesHandler lists the active overrides on GET, sets one on PUT
+// with a body like {"app": "shop", "log": "main", "min_level": "warn",
+// "ttl_seconds": 1800} and ends one early on DELETE with app= and log=.
+func levelOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := getConfig()
+	if cfg == nil {
+		writeError(w, r, http.StatusBadRequest, "config not loaded; start server with -config flag")
+		return
+	}
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, levelOverrideSnapshot(r, cfg, q.Get("app"), q.Get("log")))
+	case http.MethodPut:
+		var o LevelOverride
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if err := checkOverrideTarget(r, cfg, o.App, o.Log); err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		level := normalizeLevel(o.MinLevel)
+		if _, ok := levelRanks[level]; !ok {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid min_level %q (expected debug, info, warn or error)", o.MinLevel))
+			return
+		}
+		o.MinLevel = level
+		ttl := defaultLevelOverrideTTL
+		if o.TTLSeconds != 0 {
+			ttl = time.Duration(o.TTLSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > maxLevelOverrideTTL {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(maxLevelOverrideTTL.Seconds())))
+			return
+		}
+		o.TTLSeconds = int(ttl.Seconds())
+		o.Expires = time.Now().Add(ttl).UTC()
+
+		levelOverridesMu.Lock()
+		levelOverrides[fanOutTarget{app: o.App, key: o.Log}] = o
+		levelOverridesMu.Unlock()
+		slog.InfoContext(r.Context(), "level override set", "app", o.App, "log", o.Log, "min_level", o.MinLevel, "expires", o.Expires)
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodDelete:
+		app, key := q.Get("app"), q.Get("log")
+		if err := checkOverrideTarget(r, cfg, app, key); err != nil {
+			writeErrorFor(w, r, err)
+			return
+		}
+		if _, ok := activeLevelOverride(app, key, time.Now()); !ok {
+			writeErrorFor(w, r, errNotFound(fmt.Sprintf("no level override for %s/%s", app, key)))
+			return
+		}
+		levelOverridesMu.Lock()
+		delete(levelOverrides, fanOutTarget{app: app, key: key})
+		levelOverridesMu.Unlock()
+		slog.InfoContext(r.Context(), "level override removed", "app", app, "log", key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET, PUT and DELETE allowed")
+	}
+}
+
+// checkOverrideTarget reports a missing or invisible target as not found.
+func checkOverrideTarget(r *http.Request, cfg *Config, app, key string) error {
+	if app == "" || key == "" {
+		return fmt.Errorf("must provide app and log")
+	}
+	if _, ok := cfg.Apps[app].Logs[key]; !ok || !appVisible(r.Context(), cfg, app) {
+		return errNotFound(fmt.Sprintf("unknown log target %s/%s", app, key))
+	}
+	return nil
+}
+
+// levelOverrideSnapshot lists the active overrides the caller may see.
+func levelOverrideSnapshot(r *http.Request, cfg *Config, app, key string) []LevelOverride {
+	now := time.Now()
+	levelOverridesMu.Lock()
+	targets := make([]fanOutTarget, 0, len(levelOverrides))
+	for t := range levelOverrides {
+		targets = append(targets, t)
+	}
+	levelOverridesMu.Unlock()
+
+	out := []LevelOverride{}
+	for _, t := range targets {
+		if (app != "" && t.app != app) || (key != "" && t.key != key) || !appVisible(r.Context(), cfg, t.app) {
+			continue
+		}
+		if o, ok := activeLevelOverride(t.app, t.key, now); ok {
+			out = append(out, o)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].App != out[j].App {
+			return out[i].App < out[j].App
+		}
+		return out[i].Log < out[j].Log
+	})
+	return out
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// ===================== LOG TAIL CACHE =====================
+//
+
+// defaultLogCacheMB is the tail cache budget when server.log_cache_mb is
+// unset. A negative value turns the cache off.
+const defaultLogCacheMB = 16
+
+// logCacheKey identifies one tail read of a file.
+type logCacheKey struct {
+	path    string
+	lines   int
+	maxLine int
+	maxRead int64
+}
+
+type logCacheEntry struct {
+	key     logCacheKey
+	modTime time.Time
+	size    int64
+	text    string
+}
+
+// logTailCache is an LRU of recent file tails. Entries are only served while
+// the file's mtime and size still match, so dashboards polling an idle file
+// skip the read entirely and a changed file is always re-read.
+type logTailCache struct {
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[logCacheKey]*list.Element
+	bytes   int64
+}
+
+var logCache = &logTailCache{
+	order:   list.New(),
+	entries: make(map[logCacheKey]*list.Element),
+}
+
+// logCacheBudget returns the configured budget in bytes (0 when disabled).
+func logCacheBudget() int64 {
+	mb := defaultLogCacheMB
+	if cfg := getConfig(); cfg != nil && cfg.Server != nil && cfg.Server.LogCacheMB != 0 {
+		mb = cfg.Server.LogCacheMB
+	}
+	if mb < 0 {
+		return 0
+	}
+	return int64(mb) << 20
+}
+
+// cachedTailFileLines is tailFileLines behind logCache.
+func cachedTailFileLines(ctx context.Context, path string, n, maxLine int, maxRead int64) (string, error) {
+	budget := logCacheBudget()
+	if budget == 0 {
+		return tailFileLines(ctx, path, n, maxLine, maxRead)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		// Let tailFileLines produce the usual error.
+		return tailFileLines(ctx, path, n, maxLine, maxRead)
+	}
+
+	key := logCacheKey{path: path, lines: n, maxLine: maxLine, maxRead: maxRead}
+	if text, ok := logCache.get(key, info); ok {
+		return text, nil
+	}
+
+	text, err := tailFileLines(ctx, path, n, maxLine, maxRead)
+	if err != nil {
+		return "", err
+	}
+	logCache.put(&logCacheEntry{
+		key:     key,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		text:    text,
+	}, budget)
+	return text, nil
+}
+
+func (c *logTailCache) get(key logCacheKey, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*logCacheEntry)
+	if !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		c.remove(elem)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.text, true
+}
+
+func (c *logTailCache) put(entry *logCacheEntry, budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	if int64(len(entry.text)) > budget {
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.bytes += int64(len(entry.text))
+
+	for c.bytes > budget {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *logTailCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*logCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.text))
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== TIME RANGE READS =====================
+//
+
+// timeIndexInterval is the minimum distance between two index entries. A
+// range read starts at most this far before the first matching line.
+const timeIndexInterval = 256 << 10
+
+// timeIndexLineBytes is how much of a line indexing parses. A leading
+// timestamp needs a few bytes, but the time field of a JSON or logfmt line
+// can sit anywhere in it.
+const timeIndexLineBytes = 4 << 10
+
+// rangeLogSource is implemented by sources that can answer since/until
+// queries.
+type rangeLogSource interface {
+	ReadLogRange(ctx context.Context, since, until time.Time, lines int) (string, error)
+}
+
+// parseTimeRange reads the since/until query parameters in any form
+// parseTimeParam accepts.
+func parseTimeRange(r *http.Request) (since, until time.Time, err error) {
+	q := r.URL.Query()
+	now := time.Now()
+	if v := q.Get("since"); v != "" {
+		if since, err = parseTimeParam(v, now); err != nil {
+			return since, until, fmt.Errorf("invalid 'since': %v", err)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if until, err = parseTimeParam(v, now); err != nil {
+			return since, until, fmt.Errorf("invalid 'until': %v", err)
+		}
+	}
+	if !since.IsZero() && !until.IsZero() && !until.After(since) {
+		return since, until, fmt.Errorf("'until' must be after 'since'")
+	}
+	return since, until, nil
+}
+
+// readLogSource reads the last lines of src, limited to [since, until) when
+// either bound is set.
+func readLogSource(ctx context.Context, src LogSource, lines int, since, until time.Time) (string, error) {
+	start := time.Now()
+	defer func() {
+		slog.DebugContext(ctx, "source read", "source", fmt.Sprintf("%T", src), "duration_ms", time.Since(start).Milliseconds())
+	}()
+
+	if since.IsZero() && until.IsZero() {
+		return src.ReadLogs(ctx, lines)
+	}
+	rs, ok := src.(rangeLogSource)
+	if !ok {
+		return "", fmt.Errorf("since/until are not supported by this source")
+	}
+	return rs.ReadLogRange(ctx, since, until, lines)
+}
+
+func (f *FileLogSource) ReadLogRange(ctx context.Context, since, until time.Time, lines int) (string, error) {
+	ctx, cancel := f.withTimeout(ctx)
+	defer cancel()
+	text, err := readFileRange(ctx, f.Path, since, until, lines, f.MaxLineBytes, f.MaxReadBytes)
+	return text, f.timeoutError(ctx, err)
+}
+
+// logTimeRegex matches a leading ISO 8601 timestamp with an optional
+// fraction and zone.
+var logTimeRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})[ T](\d{2}:\d{2}:\d{2})([.,]\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+
+// parseLogTime reads when a line was written: its leading timestamp or, for
+// JSON and logfmt lines, their time field. Range reads, search and clock
+// offset inference all use it, so they agree on a line's time.
+func parseLogTime(line string) (time.Time, bool) {
+	if at, ok := parseStamp(line); ok {
+		return at, true
+	}
+	if !strings.HasPrefix(line, "{") && !strings.Contains(line, "=") {
+		return time.Time{}, false
+	}
+	_, record := classifyLine(line)
+	if record == nil {
+		return time.Time{}, false
+	}
+	v, ok := fieldValue(record, "", defaultTimestampFields)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseStamp(formatRecordTime(v))
+}
+
+// parseStamp reads the ISO 8601 timestamp s starts with. A stamp with a zone
+// (Z or ±hh:mm) is read in it; one without is taken as agent-local time.
+func parseStamp(s string) (time.Time, bool) {
+	m := logTimeRegex.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+	stamp := m[1] + "T" + m[2] + strings.Replace(m[3], ",", ".", 1)
+	zone := m[4]
+	if zone == "" {
+		t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", stamp, time.Local)
+		return t, err == nil
+	}
+	if zone != "Z" && !strings.Contains(zone, ":") {
+		zone = zone[:3] + ":" + zone[3:]
+	}
+	t, err := time.Parse(time.RFC3339Nano, stamp+zone)
+	return t, err == nil
+}
+
+type timeIndexEntry struct {
+	at     time.Time
+	offset int64
+}
+
+// fileTimeIndex is a sparse timestamp→offset index of one file. It is
+// extended from where it left off on each range read, so a growing log is
+// only ever scanned once; a truncated or replaced file is re-indexed.
+type fileTimeIndex struct {
+	mu      sync.Mutex
+	file    os.FileInfo
+	indexed int64
+	entries []timeIndexEntry
+}
+
+// maxTimeIndexes bounds how many files keep an index. Targets dropped by a
+// reload, rotated-away paths and one-off reads age out least recently used
+// first; an evicted file is simply re-indexed on its next range read.
+const maxTimeIndexes = 256
+
+type timeIndexItem struct {
+	path string
+	idx  *fileTimeIndex
+}
+
+var (
+	timeIndexesMu    sync.Mutex
+	timeIndexesOrder = list.New() // front is most recently used
+	timeIndexes      = map[string]*list.Element{}
+)
+
+func timeIndexFor(path string) *fileTimeIndex {
+	timeIndexesMu.Lock()
+	defer timeIndexesMu.Unlock()
+	if elem, ok := timeIndexes[path]; ok {
+		timeIndexesOrder.MoveToFront(elem)
+		return elem.Value.(*timeIndexItem).idx
+	}
+	idx := &fileTimeIndex{}
+	timeIndexes[path] = timeIndexesOrder.PushFront(&timeIndexItem{path: path, idx: idx})
+	for timeIndexesOrder.Len() > maxTimeIndexes {
+		item := timeIndexesOrder.Remove(timeIndexesOrder.Back()).(*timeIndexItem)
+		delete(timeIndexes, item.path)
+	}
+	return idx
+}
+
+// update indexes [idx.indexed, end) of f, scanning at most maxRead bytes
+// (when positive); a later update continues where this one stopped.
+func (idx *fileTimeIndex) update(ctx context.Context, f *os.File, info os.FileInfo, enc textEncoding, start, end, maxRead int64) error {
+	if idx.file == nil || !os.SameFile(idx.file, info) || end < idx.indexed {
+		idx.entries = nil
+		idx.indexed = start
+	}
+	idx.file = info
+
+	stop := end
+	if maxRead > 0 && end-idx.indexed > maxRead {
+		stop = idx.indexed + maxRead
+	}
+	err := forEachLine(ctx, f, enc, idx.indexed, stop, timeIndexLineBytes, func(offset int64, line string) bool {
+		idx.indexed = offset
+		n := len(idx.entries)
+		if n > 0 && offset < idx.entries[n-1].offset+timeIndexInterval {
+			return true
+		}
+		if at, ok := parseLogTime(line); ok {
+			idx.entries = append(idx.entries, timeIndexEntry{at: at, offset: offset})
+		}
+		return true
+	})
+	return err
+}
+
+// seek returns the offset of the last indexed line stamped before since.
+func (idx *fileTimeIndex) seek(since time.Time, start int64) int64 {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return !idx.entries[i].at.Before(since)
+	})
+	if i == 0 {
+		return start
+	}
+	return idx.entries[i-1].offset
+}
+
+// readFileRange returns the last n lines of path (all if n <= 0) stamped in
+// [since, until). Lines without a timestamp, such as stack trace frames,
+// belong to the closest stamped line above them.
+func readFileRange(ctx context.Context, path string, since, until time.Time, n, maxLine int, maxRead int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+	enc, start, err := detectEncoding(f)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	end := start + (info.Size()-start)/enc.unitSize()*enc.unitSize()
+
+	from := start
+	if !since.IsZero() {
+		idx := timeIndexFor(path)
+		idx.mu.Lock()
+		err := idx.update(ctx, f, info, enc, start, end, maxRead)
+		from = idx.seek(since, start)
+		idx.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	matched, ok, err := scanFileRange(ctx, f, enc, from, end, since, until, n, maxLine, maxRead, false)
+	// When the index cannot place since within maxRead of the range, because
+	// it does not reach that far yet or its entries are too sparse, fall back
+	// to the last maxRead bytes.
+	if window := end - maxRead/enc.unitSize()*enc.unitSize(); err == nil && !ok && !since.IsZero() && window > from {
+		matched, ok, err = scanFileRange(ctx, f, enc, window, end, since, until, n, maxLine, maxRead, true)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("time range covers more than max_read_bytes (%d) of %s; narrow since/until", maxRead, path)
+	}
+
+	if n > 0 && len(matched) > n {
+		matched = matched[len(matched)-n:]
+	}
+	if len(matched) == 0 {
+		return "", nil
+	}
+	return strings.Join(matched, "\n") + "\n", nil
+}
+
+// scanFileRange collects the lines of [from, end) stamped in [since, until),
+// keeping at least the last n. It reports false when the range needs more
+// than maxRead bytes. A tail scan starts mid-line, so it skips the first
+// line and is only complete if it found n lines or one older than since.
+func scanFileRange(ctx context.Context, f *os.File, enc textEncoding, from, end int64, since, until time.Time, n, maxLine int, maxRead int64, tail bool) ([]string, bool, error) {
+	var (
+		matched  []string
+		stamp    time.Time
+		first    time.Time
+		overread bool
+	)
+	err := forEachLine(ctx, f, enc, from, end, maxLine, func(offset int64, line string) bool {
+		if maxRead > 0 && offset-from > maxRead {
+			overread = true
+			return false
+		}
+		if tail && offset == from {
+			return true
+		}
+		if at, ok := parseLogTime(line); ok {
+			stamp = at
+			if first.IsZero() {
+				first = at
+			}
+		}
+		if !until.IsZero() && !stamp.IsZero() && !stamp.Before(until) {
+			return false
+		}
+		if !since.IsZero() && (stamp.IsZero() || stamp.Before(since)) {
+			return true
+		}
+		matched = append(matched, line)
+		if n > 0 && len(matched) > 2*n {
+			matched = append(matched[:0], matched[len(matched)-n:]...)
+		}
+		return true
+	})
+	if tail && (n <= 0 || len(matched) < n) && (first.IsZero() || !first.Before(since)) {
+		overread = true
+	}
+	return matched, !overread, err
+}
+
+// forEachLine calls fn with the start offset and text of every line in
+// [from, end), reading forward in blocks. Lines longer than maxLine bytes
+// (when positive) are cut and marked. fn returns false to stop early.
+func forEachLine(ctx context.Context, f *os.File, enc textEncoding, from, end int64, maxLine int, fn func(offset int64, line string) bool) error {
+	unit := enc.unitSize()
+	buf := make([]byte, tailBlockSize)
+
+	var (
+		pending   []byte
+		lineStart = from
+		lineSize  int64
+	)
+	emit := func() bool {
+		line := strings.TrimSuffix(decodeText(enc, pending), "\r")
+		if int64(len(pending)) < lineSize {
+			line = truncateMarked(line, lineSize)
+		}
+		return fn(lineStart, line)
+	}
+
+	for pos := from; pos < end; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		size := min(int64(tailBlockSize), end-pos)
+		block := buf[:size]
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return fmt.Errorf("read file: %w", err)
+		}
+
+		segStart := int64(0)
+		for i := int64(0); i+unit <= size; i += unit {
+			if !enc.isNewline(block[i : i+unit]) {
+				continue
+			}
+			pending, lineSize = appendCapped(pending, block[segStart:i], lineSize, maxLine, unit)
+			if !emit() {
+				return nil
+			}
+			pending, lineSize = pending[:0], 0
+			segStart = i + unit
+			lineStart = pos + segStart
+		}
+		pending, lineSize = appendCapped(pending, block[segStart:], lineSize, maxLine, unit)
+		pos += size
+	}
+
+	if lineSize > 0 {
+		emit()
+	}
+	return nil
+}
+
+// appendCapped adds seg to a line being assembled, keeping at most maxLine
+// bytes (whole code units) while still counting the full size.
+func appendCapped(line, seg []byte, size int64, maxLine int, unit int64) ([]byte, int64) {
+	size += int64(len(seg))
+	if maxLine > 0 {
+		room := (int64(maxLine)/unit)*unit - int64(len(line))
+		if room < int64(len(seg)) {
+			seg = seg[:max(room, 0)]
+		}
+	}
+	return append(line, seg...), size
+}
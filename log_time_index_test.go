@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogTime(t *testing.T) {
+	local := func(s string) time.Time {
+		at, err := time.ParseInLocation("2006-01-02T15:04:05.999", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	utc := func(s string) time.Time {
+		at, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{"2024-05-01T12:00:00Z INFO started", utc("2024-05-01T12:00:00Z"), true},
+		{"2024-05-01 12:00:00.250+02:00 WARN slow", utc("2024-05-01T10:00:00.25Z"), true},
+		{"2024-05-01T12:00:00,5-0500 ERROR boom", utc("2024-05-01T17:00:00.5Z"), true},
+		{"2024-05-01 12:00:00 INFO no zone", local("2024-05-01T12:00:00"), true},
+		{`{"ts":"2024-05-01T12:00:00Z","msg":"json"}`, utc("2024-05-01T12:00:00Z"), true},
+		{`{"time":1714564800,"msg":"epoch"}`, utc("2024-05-01T12:00:00Z"), true},
+		{`time="2024-05-01T12:00:00+01:00" level=info msg=logfmt`, utc("2024-05-01T11:00:00Z"), true},
+		{"\tat com.shop.Order.load(Order.java:42)", time.Time{}, false},
+		{"May  1 12:00:00 host sshd[1]: syslog", time.Time{}, false},
+		{`{"msg":"no time"}`, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := parseLogTime(tt.line)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Fatalf("parseLogTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantSince time.Duration
+		wantUntil time.Duration
+		wantErr   string
+	}{
+		{"", 0, 0, ""},
+		{"since=-15m", -15 * time.Minute, 0, ""},
+		{"since=1h&until=now-30m", -time.Hour, -30 * time.Minute, ""},
+		{"since=now-2d", -48 * time.Hour, 0, ""},
+		{"since=yesterday", 0, 0, "invalid 'since'"},
+		{"until=now%2Bx", 0, 0, "invalid 'until'"},
+		{"since=-5m&until=-10m", 0, 0, "'until' must be after 'since'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			before := time.Now()
+			since, until, err := parseTimeRange(httptest.NewRequest("GET", "/logs?"+tt.query, nil))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTimeRange() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimeRange() error = %v", err)
+			}
+			check := func(name string, got time.Time, want time.Duration) {
+				if want == 0 {
+					if !got.IsZero() {
+						t.Fatalf("%s = %v, want unset", name, got)
+					}
+					return
+				}
+				if d := got.Sub(before.Add(want)); d < 0 || d > time.Second {
+					t.Fatalf("%s = %v, want about now%v", name, got, want)
+				}
+			}
+			check("since", since, tt.wantSince)
+			check("until", until, tt.wantUntil)
+		})
+	}
+}
+
+func TestParseTimeParam(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-05-01T10:00:00Z", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), false},
+		{"1714557600", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), false},
+		{"1714557600500", time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC), false},
+		{"now", now, false},
+		{"now+5m", now.Add(5 * time.Minute), false},
+		{"-1h", now.Add(-time.Hour), false},
+		{"7d", now.Add(-7 * 24 * time.Hour), false},
+		{"now*5m", time.Time{}, true},
+		{"0s", time.Time{}, true},
+		{"soon", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTimeParam(tt.in, now)
+			if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+				t.Fatalf("parseTimeParam(%q) = %v, %v, want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadFileRange(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&b, "%s INFO request %05d served with some padding\n", base.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+		if i%1000 == 0 {
+			b.WriteString("\tat stack.frame(Without.java:1)\n")
+		}
+	}
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		n            int
+		maxRead      int64
+		wantFirst    string
+		wantLines    int
+		wantErr      bool
+	}{
+		{"range", at(100), at(103), 0, 0, "request 00100", 3, false},
+		{"frames follow their line", at(1000), at(1001), 0, 0, "request 01000", 2, false},
+		{"last n of range", at(5000), at(6000), 2, 0, "request 05998", 2, false},
+		{"until only", time.Time{}, at(2), 0, 0, "request 00000", 3, false},
+		{"old range within max read", at(10), at(13), 0, 64 << 10, "request 00010", 3, false},
+		{"tail window covers recent range", at(19990), time.Time{}, 5, 64 << 10, "request 19995", 5, false},
+		{"range beyond max read", at(10), time.Time{}, 0, 64 << 10, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFileRange(context.Background(), path, tt.since, tt.until, tt.n, 1<<20, tt.maxRead)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readFileRange() = %d bytes, want an error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readFileRange() error = %v", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+			if len(lines) != tt.wantLines || !strings.Contains(lines[0], tt.wantFirst) {
+				t.Fatalf("readFileRange() = %d lines starting %q, want %d starting with %q", len(lines), lines[0], tt.wantLines, tt.wantFirst)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//
+// ===================== SELF-LOGGING =====================
+//
+
+type LoggingConfig struct {
+	// Level is debug, info, warn or error (default info).
+	Level string `yaml:"level,omitempty"`
+	// Format is text or json (default text).
+	Format string `yaml:"format,omitempty"`
+}
+
+// logLevel is shared by every handler configureLogging installs, so the
+// level can change at runtime without rebuilding the logger.
+var logLevel = new(slog.LevelVar)
+
+// configureLogging installs the agent's logger from config. It runs at
+// startup and on every reload; a level set through /admin/log-level lasts
+// until the next reload.
+func configureLogging(c *LoggingConfig) {
+	if c == nil {
+		c = &LoggingConfig{}
+	}
+	level, _ := parseLogLevel(c.Level)
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if strings.EqualFold(c.Format, "json") {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// requestLogMiddleware logs one line per request once it completes.
+func requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote", remoteIP(r),
+		)
+	})
+}
+
+// logLevelHandler reports the current level on GET and changes it on PUT
+// with a body like {"level": "debug"}.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil || req.Level == "" {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid level %q (expected debug, info, warn or error)", req.Level))
+			return
+		}
+		logLevel.Set(level)
+		slog.Info("log level changed", "level", level.String())
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET and PUT allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"level": strings.ToLower(logLevel.Level().String()),
+	})
+}
+
+func validateLoggingConfig(c *LoggingConfig) []string {
+	var problems []string
+	if _, err := parseLogLevel(c.Level); err != nil {
+		problems = append(problems, "logging.level: "+err.Error())
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "text", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("logging.format: unknown format %q (expected text or json)", c.Format))
+	}
+	return problems
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,15 +26,104 @@ import (
 //
 
 type Config struct {
-	Server *ServerConfig        `yaml:"server,omitempty"`
-	AI     *AIConfig            `yaml:"ai,omitempty"`
-	Apps   map[string]AppConfig `yaml:"apps"`
+	Version    int                  `yaml:"version,omitempty"`
+	Include    []string             `yaml:"include,omitempty"`
+	Server     *ServerConfig        `yaml:"server,omitempty"`
+	AI         *AIConfig            `yaml:"ai,omitempty"`
+	Auth       *AuthConfig          `yaml:"auth,omitempty"`
+	Redaction  *RedactionConfig     `yaml:"redaction,omitempty"`
+	Audit      *AuditConfig         `yaml:"audit,omitempty"`
+	Logging    *LoggingConfig       `yaml:"logging,omitempty"`
+	Fleet      *FleetConfig         `yaml:"fleet,omitempty"`
+	Discovery  *DiscoveryConfig     `yaml:"discovery,omitempty"`
+	Extractors *ExtractorsConfig    `yaml:"extractors,omitempty"`
+	Enrichment *EnrichmentConfig    `yaml:"enrichment,omitempty"`
+	Volume     *VolumeConfig        `yaml:"volume,omitempty"`
+	Disk       *DiskConfig          `yaml:"disk,omitempty"`
+	Analyzers  []AnalyzerConfig     `yaml:"analyzers,omitempty"`
+	Defaults   *TargetDefaults      `yaml:"defaults,omitempty"`
+	Apps       map[string]AppConfig `yaml:"apps"`
+	// Tenants partitions the apps between teams sharing one agent.
+	Tenants map[string]TenantConfig `yaml:"tenants,omitempty"`
+
+	// warnings holds non-fatal validation findings from loadConfig.
+	warnings []string
+	// appSources maps each app to the file that defines it.
+	appSources map[string]string
+	// generation numbers the configs setConfig has made active.
+	generation uint64
 }
 
 type ServerConfig struct {
-	Addr         string `yaml:"addr,omitempty"`
-	DefaultLines int    `yaml:"default_lines,omitempty"`
-	MaxLines     int    `yaml:"max_lines,omitempty"`
+	Addr         string           `yaml:"addr,omitempty"`
+	TLS          *TLSConfig       `yaml:"tls,omitempty"`
+	RateLimit    *RateLimitConfig `yaml:"rate_limit,omitempty"`
+	CORS         *CORSConfig      `yaml:"cors,omitempty"`
+	AllowedCIDRs *AllowedCIDRs    `yaml:"allowed_cidrs,omitempty"`
+	FanOut       *FanOutConfig    `yaml:"fan_out,omitempty"`
+	Timeouts     *ServerTimeouts  `yaml:"timeouts,omitempty"`
+	// MaxConnections caps open client connections (0 means unlimited).
+	MaxConnections int `yaml:"max_connections,omitempty"`
+	// ShutdownGraceSeconds is how long in-flight requests may run after
+	// SIGINT/SIGTERM before they are cancelled (default 15).
+	ShutdownGraceSeconds int `yaml:"shutdown_grace_seconds,omitempty"`
+	// DisableSecurityHeaders turns off the default nosniff/frame/CSP headers.
+	DisableSecurityHeaders bool `yaml:"disable_security_headers,omitempty"`
+	// DisableCompression turns off gzip for /logs responses.
+	DisableCompression bool `yaml:"disable_compression,omitempty"`
+	// EnableDebug exposes pprof and expvar under /debug/ (action scope).
+	EnableDebug bool `yaml:"enable_debug,omitempty"`
+	// LogCacheMB is the memory budget for cached file tails (0 means the
+	// default, negative disables the cache).
+	LogCacheMB int `yaml:"log_cache_mb,omitempty"`
+	// UnparsedWarnRatio logs a warning when more than this share of a
+	// read's lines can't be parsed (default 0.5, negative disables).
+	UnparsedWarnRatio float64 `yaml:"unparsed_warn_ratio,omitempty"`
+	ReadSettings      `yaml:",inline"`
+}
+
+// ReadSettings are the per-read knobs that can be set on the server and
+// overridden per app and per log target. Zero values mean "inherit".
+type ReadSettings struct {
+	DefaultLines int `yaml:"default_lines,omitempty" json:"default_lines,omitempty"`
+	MaxLines     int `yaml:"max_lines,omitempty" json:"max_lines,omitempty"`
+	// MaxLineBytes cuts longer lines and marks them as truncated.
+	MaxLineBytes int `yaml:"max_line_bytes,omitempty" json:"max_line_bytes,omitempty"`
+	// MaxReadBytes bounds how much of a file one read may scan: a tail
+	// returns only the lines that fit, a since/until read fails.
+	MaxReadBytes int64 `yaml:"max_read_bytes,omitempty" json:"max_read_bytes,omitempty"`
+	// ReadTimeoutSeconds is the deadline for one file read.
+	ReadTimeoutSeconds int `yaml:"read_timeout_seconds,omitempty" json:"read_timeout_seconds,omitempty"`
+}
+
+// overlay returns s with every non-zero field of o applied on top.
+func (s ReadSettings) overlay(o ReadSettings) ReadSettings {
+	if o.DefaultLines > 0 {
+		s.DefaultLines = o.DefaultLines
+	}
+	if o.MaxLines > 0 {
+		s.MaxLines = o.MaxLines
+	}
+	if o.MaxLineBytes > 0 {
+		s.MaxLineBytes = o.MaxLineBytes
+	}
+	if o.MaxReadBytes > 0 {
+		s.MaxReadBytes = o.MaxReadBytes
+	}
+	if o.ReadTimeoutSeconds > 0 {
+		s.ReadTimeoutSeconds = o.ReadTimeoutSeconds
+	}
+	return s
+}
+
+// fileSource builds a FileLogSource that enforces s.
+func (s ReadSettings) fileSource(path string) *FileLogSource {
+	return &FileLogSource{
+		Path:         path,
+		MaxLineBytes: s.MaxLineBytes,
+		MaxReadBytes: s.MaxReadBytes,
+		Timeout:      time.Duration(s.ReadTimeoutSeconds) * time.Second,
+	}
 }
 
 type AIConfig struct {
@@ -40,41 +133,129 @@ type AIConfig struct {
 }
 
 type AppConfig struct {
-	Logs map[string]LogTarget `yaml:"logs"`
+	ReadSettings `yaml:",inline"`
+	// Labels are attached to every line of the app's logs.
+	Labels map[string]string    `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Logs   map[string]LogTarget `yaml:"logs" json:"logs"`
 }
 
 type LogTarget struct {
-	Type string `yaml:"type"`
-	Path string `yaml:"path,omitempty"`
-	URL  string `yaml:"url,omitempty"`
+	Type         string            `yaml:"type" json:"type"`
+	Path         string            `yaml:"path,omitempty" json:"path,omitempty"`
+	URL          string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Service      string            `yaml:"service,omitempty" json:"service,omitempty"`
+	Redact       []RedactRule      `yaml:"redact,omitempty" json:"redact,omitempty"`
+	HTTP         *HTTPClientConfig `yaml:"http,omitempty" json:"http,omitempty"`
+	Response     *ResponseConfig   `yaml:"response,omitempty" json:"response,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	ReadSettings `yaml:",inline"`
+
+	// ClockOffset corrects a host clock that is off: a duration added to
+	// the target's timestamps (-4m for a clock 4 minutes fast), or auto.
+	ClockOffset string `yaml:"clock_offset,omitempty" json:"clock_offset,omitempty"`
+
+	// discovered marks targets added by discovery rather than the config.
+	discovered bool
 }
 
-var (
-	globalConfig *Config
-)
-
 func loadConfig(path string) (*Config, error) {
+	cfg, err := decodeConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.appSources = make(map[string]string, len(cfg.Apps))
+	for name := range cfg.Apps {
+		cfg.appSources[name] = path
+	}
+	if err := mergeIncludes(cfg, path); err != nil {
+		return nil, err
+	}
+
+	applyTargetDefaults(cfg)
+	applyConfigDefaults(cfg)
+
+	warnings, err := validateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.warnings = append(cfg.warnings, warnings...)
+
+	return cfg, nil
+}
+
+// decodeConfigFile reads a single YAML file, expands env/secret references
+// and decodes it strictly. It does not follow includes or apply defaults.
+func decodeConfigFile(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
 
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	fromVersion, err := migrateConfigNode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := expandConfigNode(&doc, filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("expand config: %w", err)
+	}
+	if data, err = yaml.Marshal(&doc); err != nil {
+		return nil, fmt.Errorf("expand config: %w", err)
+	}
+
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	if fromVersion < currentConfigVersion {
+		cfg.warnings = append(cfg.warnings, fmt.Sprintf("%s: config version %d was migrated in memory to %d; run migrate-config to update the file", path, fromVersion, currentConfigVersion))
+	}
 
+	return &cfg, nil
+}
+
+// readSettings resolves the effective settings for a read: server defaults,
+// then the app's overrides, then the target's. Empty app/logKey (ad-hoc
+// source= queries) get the server settings.
+func (c *Config) readSettings(appName, logKey string) ReadSettings {
+	settings := ReadSettings{DefaultLines: 100, MaxLines: 1000, MaxLineBytes: 1 << 20, MaxReadBytes: 256 << 20, ReadTimeoutSeconds: 30}
+	if c == nil {
+		return settings
+	}
+	if c.Server != nil {
+		settings = settings.overlay(c.Server.ReadSettings)
+	}
+	if app, ok := c.Apps[appName]; ok {
+		settings = settings.overlay(app.ReadSettings)
+		if target, ok := app.Logs[logKey]; ok {
+			settings = settings.overlay(target.ReadSettings)
+		}
+	}
+	// A lower max_lines set further down must also cap the inherited default.
+	if settings.DefaultLines > settings.MaxLines {
+		settings.DefaultLines = settings.MaxLines
+	}
+	return settings
+}
+
+func applyConfigDefaults(cfg *Config) {
 	if cfg.Server == nil {
 		cfg.Server = &ServerConfig{}
 	}
-	if cfg.Server.DefaultLines <= 0 {
-		cfg.Server.DefaultLines = 100
-	}
 	if cfg.Server.MaxLines <= 0 {
 		cfg.Server.MaxLines = 1000
 	}
-
-	return &cfg, nil
+	// A filled-in default must not exceed a smaller max_lines the user set;
+	// only explicit values are checked against each other.
+	if cfg.Server.DefaultLines <= 0 {
+		cfg.Server.DefaultLines = min(100, cfg.Server.MaxLines)
+	}
 }
 
 //
@@ -87,54 +268,46 @@ type LogSource interface {
 
 type FileLogSource struct {
 	Path string
+	// MaxLineBytes cuts longer lines (0 means unlimited).
+	MaxLineBytes int
+	// MaxReadBytes caps the bytes scanned per read (0 means unlimited).
+	MaxReadBytes int64
+	// Timeout is the deadline for one read (0 means only ctx's).
+	Timeout time.Duration
 }
 
-func (f *FileLogSource) ReadLogs(ctx context.Context, lines int) (string, error) {
-	file, err := os.Open(f.Path)
-	if err != nil {
-		return "", fmt.Errorf("open file: %w", err)
-	}
-	defer file.Close()
-
-	var allLines []string
-	scanner := bufio.NewScanner(file)
-
-	// Read all lines
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		default:
-		}
-		allLines = append(allLines, scanner.Text())
-	}
+func (f *FileLogSource) LocalPath() string { return f.Path }
 
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("scan file: %w", err)
-	}
+func (f *FileLogSource) ReadLogs(ctx context.Context, lines int) (string, error) {
+	ctx, cancel := f.withTimeout(ctx)
+	defer cancel()
+	text, err := cachedTailFileLines(ctx, f.Path, lines, f.MaxLineBytes, f.MaxReadBytes)
+	return text, f.timeoutError(ctx, err)
+}
 
-	// If file is empty:
-	if len(allLines) == 0 {
-		return "", nil
+func (f *FileLogSource) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.Timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, f.Timeout)
+}
 
-	// Determine how many lines to return
-	if lines <= 0 || lines > len(allLines) {
-		lines = len(allLines)
+// timeoutError names the read deadline when it, rather than the caller,
+// ended the read.
+func (f *FileLogSource) timeoutError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && f.Timeout > 0 {
+		return fmt.Errorf("read of %s took longer than %s: %w", f.Path, f.Timeout, err)
 	}
-
-	// START from bottom
-	start := len(allLines) - lines
-	selected := allLines[start:]
-
-	// Join
-	result := strings.Join(selected, "\n") + "\n"
-	return result, nil
+	return err
 }
 
 type APILogSource struct {
-	URL    string
-	Client *http.Client
+	URL     string
+	Client  *http.Client
+	Headers http.Header
+	Retry   retryPolicy
+	// Response, when set to json or ndjson, splits the body into records.
+	Response *ResponseConfig
 }
 
 func (a *APILogSource) ReadLogs(ctx context.Context, lines int) (string, error) {
@@ -143,114 +316,143 @@ func (a *APILogSource) ReadLogs(ctx context.Context, lines int) (string, error)
 			Timeout: 10 * time.Second,
 		}
 	}
+	if a.Retry.maxAttempts == 0 {
+		a.Retry = (*RetryConfig)(nil).policy()
+	}
+
+	if !a.Response.parsed() {
+		body, _, err := a.fetchWithRetry(ctx, a.URL)
+		return string(body), err
+	}
+	return a.readRecords(ctx, lines)
+}
+
+func (a *APILogSource) fetchWithRetry(ctx context.Context, pageURL string) (body []byte, header http.Header, err error) {
+	err = withRetry(ctx, a.URL, a.Retry, func() error {
+		var err error
+		body, header, err = a.fetch(ctx, pageURL)
+		return err
+	})
+	return body, header, err
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+// fetch makes one request to the target, flagging failures worth retrying.
+// Any non-2xx status is an error, so an HTML error page never shows up as
+// log content.
+func (a *APILogSource) fetch(ctx context.Context, pageURL string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+		return nil, nil, fmt.Errorf("create request: %w", err)
 	}
+	for k, v := range a.Headers {
+		req.Header[k] = v
+	}
+	setTraceHeaders(ctx, req)
 
 	resp, err := a.Client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("do request: %w", err)
+		if ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("do request: %w", err)
+		}
+		return nil, nil, errRetryable{fmt.Errorf("do request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("remote API error: %s", resp.Status)
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		err := fmt.Errorf("remote API error: %s", resp.Status)
+		if s := strings.TrimSpace(sanitizeBinary(snippet)); s != "" {
+			err = fmt.Errorf("remote API error: %s: %s", resp.Status, s)
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, nil, errRetryable{err}
+		}
+		return nil, nil, err
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("read body: %w", err)
+		// A cut-off body is an error, never a partial result.
+		return nil, nil, errRetryable{fmt.Errorf("read body: %w", err)}
 	}
 
-	return string(bodyBytes), nil
+	return bodyBytes, resp.Header, nil
 }
 
 //
 // ===================== HELPERS =====================
 //
 
-func parseLines(r *http.Request) int {
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func parseLines(r *http.Request, settings ReadSettings) int {
 	linesStr := r.URL.Query().Get("lines")
 	if linesStr == "" {
-		if globalConfig != nil && globalConfig.Server != nil {
-			return globalConfig.Server.DefaultLines
-		}
-		return 100
+		return settings.DefaultLines
 	}
 	n, err := strconv.Atoi(linesStr)
 	if err != nil || n <= 0 {
-		if globalConfig != nil && globalConfig.Server != nil {
-			return globalConfig.Server.DefaultLines
-		}
-		return 100
+		return settings.DefaultLines
 	}
-	if globalConfig != nil && globalConfig.Server != nil && n > globalConfig.Server.MaxLines {
-		n = globalConfig.Server.MaxLines
+	if n > settings.MaxLines {
+		n = settings.MaxLines
 	}
 	return n
 }
 
-func selectSourceFromQuery(r *http.Request) (LogSource, error) {
-	source := r.URL.Query().Get("source")
-	switch source {
-	case "file":
-		path := r.URL.Query().Get("path")
-		if path == "" {
-			return nil, fmt.Errorf("missing 'path' for file source")
-		}
-		return &FileLogSource{Path: path}, nil
-	case "api":
-		url := r.URL.Query().Get("url")
-		if url == "" {
-			return nil, fmt.Errorf("missing 'url' for api source")
-		}
-		return &APILogSource{
-			URL: url,
-			Client: &http.Client{
-				Timeout: 10 * time.Second,
-			},
-		}, nil
-	default:
-		return nil, fmt.Errorf("invalid or missing 'source' (expected 'file' or 'api')")
+// selectSourceFromQuery builds an ad-hoc source from source=, path= and
+// url=, checked and created like a configured target of that type.
+func selectSourceFromQuery(r *http.Request, cfg *Config) (LogSource, error) {
+	q := r.URL.Query()
+	target := LogTarget{Type: q.Get("source"), Path: q.Get("path"), URL: q.Get("url")}
+	st, ok := lookupSourceType(target.Type)
+	if !ok {
+		return nil, fmt.Errorf("invalid or missing 'source' (expected %s)", sourceTypeList())
+	}
+	if problems, _ := st.validate("source", target); len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return st.create(cfg, "", "", target)
+}
+
+// readTargetKey names the log target a /logs request reads, so limits and
+// caches apply per target regardless of whether it was addressed via
+// app+log or an ad-hoc source= query.
+func readTargetKey(r *http.Request) string {
+	q := r.URL.Query()
+	if q.Get("app") != "" && q.Get("log") != "" {
+		return "app:" + q.Get("app") + "/" + q.Get("log")
 	}
+	if source := q.Get("source"); source != "" {
+		return source + ":" + q.Get("path") + q.Get("url")
+	}
+	return ""
 }
 
-func sourceFromConfig(appName, logKey string) (LogSource, error) {
-	if globalConfig == nil {
+func sourceFromConfig(cfg *Config, appName, logKey string) (LogSource, error) {
+	if cfg == nil {
 		return nil, fmt.Errorf("config not loaded; start server with -config flag")
 	}
 
-	appCfg, ok := globalConfig.Apps[appName]
+	appCfg, ok := cfg.Apps[appName]
 	if !ok {
-		return nil, fmt.Errorf("unknown app %q", appName)
+		return nil, errNotFound(fmt.Sprintf("unknown app %q", appName))
 	}
 
 	target, ok := appCfg.Logs[logKey]
 	if !ok {
-		return nil, fmt.Errorf("unknown log key %q for app %q", logKey, appName)
+		return nil, errNotFound(fmt.Sprintf("unknown log key %q for app %q", logKey, appName))
 	}
 
-	switch target.Type {
-	case "file":
-		if target.Path == "" {
-			return nil, fmt.Errorf("log %q for app %q: missing path", logKey, appName)
-		}
-		return &FileLogSource{Path: target.Path}, nil
-	case "api":
-		if target.URL == "" {
-			return nil, fmt.Errorf("log %q for app %q: missing url", logKey, appName)
-		}
-		return &APILogSource{
-			URL: target.URL,
-			Client: &http.Client{
-				Timeout: 10 * time.Second,
-			},
-		}, nil
-	default:
-		return nil, fmt.Errorf("log %q for app %q: invalid type %q (expected file or api)", logKey, appName, target.Type)
+	st, ok := lookupSourceType(target.Type)
+	if !ok {
+		return nil, fmt.Errorf("log %q for app %q: invalid type %q (expected %s)", logKey, appName, target.Type, sourceTypeList())
 	}
+	return st.create(cfg, appName, logKey, target)
 }
 
 // ===================== HUMAN-READABLE LOG PARSING =====================
@@ -272,28 +474,46 @@ var (
 	javaStackLine = regexp.MustCompile(`^\s*at\s+[\w.$_]+\(.*:\d+\)$`)
 )
 
-func formatLogLine(line string) map[string]interface{} {
-	result := map[string]interface{}{
-		"raw": line,
-	}
+// LogOutput is one formatted line of a /logs response.
+type LogOutput struct {
+	Raw       string `json:"raw"`
+	Type      string `json:"type,omitempty"`
+	Severity  string `json:"severity,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	// LatencyMS is set when extractors.latency finds a duration in Raw.
+	LatencyMS *float64 `json:"latency_ms,omitempty"`
+	// HTTPStatus is set when extractors.http_status finds a response code.
+	HTTPStatus int `json:"http_status,omitempty"`
+	// Client is set when extractors.access_log finds a client IP or user
+	// agent.
+	Client *ClientInfo `json:"client,omitempty"`
+	// ErrorClass is set when extractors.error_class recognizes an ERROR or
+	// WARN line, e.g. network or database.
+	ErrorClass string `json:"error_class,omitempty"`
+	// Labels come from enrichment and the app's and target's labels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func formatLogLine(line string) LogOutput {
+	result := LogOutput{Raw: line}
 
 	if timeRegex.MatchString(line) {
-		result["type"] = "timestamped"
+		result.Type = "timestamped"
 	}
 
 	if javaStackLine.MatchString(line) {
-		result["type"] = "stacktrace_line"
+		result.Type = "stacktrace_line"
 	}
 
 	switch {
 	case strings.Contains(line, "ERROR"):
-		result["severity"] = "ERROR"
+		result.Severity = "ERROR"
 	case strings.Contains(line, "WARN"):
-		result["severity"] = "WARN"
+		result.Severity = "WARN"
 	case strings.Contains(line, "INFO"):
-		result["severity"] = "INFO"
+		result.Severity = "INFO"
 	case strings.Contains(line, "DEBUG"):
-		result["severity"] = "DEBUG"
+		result.Severity = "DEBUG"
 	}
 
 	return result
@@ -304,6 +524,7 @@ func formatLogLine(line string) map[string]interface{} {
 func logsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	q := r.URL.Query()
+	cfg := getConfig()
 
 	appName := q.Get("app")
 	logKey := q.Get("log")
@@ -314,53 +535,152 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
 	)
 
 	switch {
+	case appName == fanOutWildcard || logKey == fanOutWildcard:
+		logsFanOutHandler(w, r, appName, logKey)
+		return
 	case appName != "" && logKey != "":
-		sourceImpl, err = sourceFromConfig(appName, logKey)
+		if cfg != nil && !appVisible(ctx, cfg, appName) {
+			writeErrorFor(w, r, errNotFound(fmt.Sprintf("unknown app %q", appName)))
+			return
+		}
+		sourceImpl, err = sourceFromConfig(cfg, appName, logKey)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeErrorFor(w, r, err)
 			return
 		}
 	case q.Get("source") != "":
-		sourceImpl, err = selectSourceFromQuery(r)
+		if tenantFromContext(ctx) != "" {
+			writeError(w, r, http.StatusForbidden, "ad-hoc sources are not available to tenant callers")
+			return
+		}
+		sourceImpl, err = selectSourceFromQuery(r, cfg)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 	default:
-		http.Error(w, "must provide either app+log or source", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "must provide either app+log or source")
 		return
 	}
 
-	lines := parseLines(r)
-	rawLogs, err := sourceImpl.ReadLogs(ctx, lines)
+	var target LogTarget
+	if cfg != nil {
+		target = cfg.Apps[appName].Logs[logKey]
+	}
+
+	if etag := logsETag(cfg, sourceImpl, r); etag != "" {
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			// writeNegotiated sets Vary on a full response; a 304 needs it too.
+			w.Header().Add("Vary", "Accept")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if cfg != nil && cfg.Server != nil && cfg.Server.RateLimit != nil {
+		release, ok := acquireReadSlot(readTargetKey(r), cfg.Server.RateLimit.MaxConcurrentReads)
+		if !ok {
+			tooManyRequests(w, r, time.Second, "too many concurrent reads of this target")
+			return
+		}
+		defer release()
+	}
+
+	since, until, err := parseTimeRange(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to read logs: %v", err), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	clean := sanitizeBinary([]byte(rawLogs))
+	if appName != "" && logKey != "" {
+		if offset := cfg.clockOffset(ctx, appName, logKey); offset != 0 {
+			since, until = targetRange(since, until, offset)
+			w.Header().Set("X-Clock-Offset", offset.String())
+		}
+	}
+
+	settings := cfg.readSettings(appName, logKey)
+	lines := parseLines(r, settings)
+	rawLogs, err := readLogSource(ctx, sourceImpl, lines, since, until)
+	if err != nil {
+		slog.WarnContext(ctx, "log read failed", "target", readTargetKey(r), "error", err)
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to read logs: %v", err))
+		return
+	}
+	clean := redactLogs(cfg, target, sanitizeBinary([]byte(rawLogs)))
+
+	output, truncated := formatLogOutput(cfg, clean, settings.MaxLineBytes)
+	if appName != "" && logKey != "" {
+		recordUnparsed(cfg, appName, logKey, output)
+		applyLabels(output, cfg.targetLabels(appName, logKey))
+		var filtered int
+		if output, filtered = filterLevels(output, appName, logKey); filtered > 0 {
+			w.Header().Set("X-Filtered-Lines", strconv.Itoa(filtered))
+		}
+	}
+	if truncated > 0 {
+		w.Header().Set("X-Truncated-Lines", strconv.Itoa(truncated))
+	}
+	if p := latencyPercentiles(output); p != nil {
+		w.Header().Set("X-Latency-Ms", p.String())
+	}
+	if c := httpStatusCounts(output); c != nil {
+		w.Header().Set("X-Http-Status", c.String())
+	}
+	if c := errorClassCounts(output); c != nil {
+		w.Header().Set("X-Error-Classes", c.String())
+	}
+	writeNegotiated(w, r, http.StatusOK, output)
+}
 
+// formatLogOutput turns redacted log text into the /logs response body:
+// JSON payloads pass through as-is, anything else becomes one formatted
+// entry per non-empty line. It also reports how many lines were truncated.
+func formatLogOutput(cfg *Config, clean string, maxLine int) (interface{}, int) {
 	var parsed interface{}
 	if json.Unmarshal([]byte(clean), &parsed) == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(parsed)
-		return
+		return parsed, 0
 	}
 
-	scanner := bufio.NewScanner(strings.NewReader(clean))
-	var output []map[string]interface{}
+	lines := splitLogLines(clean, maxLine)
+	output := make([]LogOutput, 0, len(lines))
+	truncated := 0
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
-		formatted := formatLogLine(line)
+		formatted := cfg.extractLine(line)
+		if formatted.Truncated {
+			truncated++
+		}
 		output = append(output, formatted)
 	}
+	return output, truncated
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(output)
+// extractLine formats one trimmed line and runs the configured extractors
+// on it.
+func (cfg *Config) extractLine(line string) LogOutput {
+	formatted := formatLogLine(line)
+	formatted.Truncated = isTruncated(line)
+	if latency := cfg.latencyExtraction(); latency != nil {
+		if ms, ok := latency.extract(line); ok {
+			formatted.LatencyMS = &ms
+		}
+	}
+	if httpStatus := cfg.httpStatusExtraction(); httpStatus != nil {
+		httpStatus.applyTo(&formatted)
+	}
+	if accessLog := cfg.accessLogExtraction(); accessLog != nil {
+		accessLog.applyTo(&formatted)
+	}
+	if errorClass := cfg.errorClassExtraction(); errorClass != nil {
+		errorClass.applyTo(&formatted)
+	}
+	return formatted
 }
 
 // ===================== /logs/analyze =====================
@@ -371,31 +691,35 @@ type AnalyzeRequest struct {
 
 func logsAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "only POST allowed")
 		return
 	}
 
 	var req AnalyzeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
 
 	// Example: ignore OpenAI key, just return sample recommendations
-	sampleResponse := map[string]interface{}{
-		"recommendations": []map[string]string{
-			{
-				"title":       "Check DEBUG logs",
-				"description": fmt.Sprintf("You sent %d log entries. Review DEBUG logs for unnecessary output.", len(req.Logs)),
-				"severity":    "LOW",
-			},
-			{
-				"title":       "Review HikariPool stats",
-				"description": "Pool cleanup messages detected frequently; ensure proper connection management.",
-				"severity":    "MEDIUM",
-			},
+	recommendations := []map[string]string{
+		{
+			"title":       "Check DEBUG logs",
+			"description": fmt.Sprintf("You sent %d log entries. Review DEBUG logs for unnecessary output.", len(req.Logs)),
+			"severity":    "LOW",
+		},
+		{
+			"title":       "Review HikariPool stats",
+			"description": "Pool cleanup messages detected frequently; ensure proper connection management.",
+			"severity":    "MEDIUM",
 		},
 	}
+	cfg := getConfig()
+	recommendations = append(recommendations, volumeRecommendations(r, cfg)...)
+	recommendations = append(recommendations, diskRecommendations(r, cfg)...)
+	sampleResponse := map[string]interface{}{
+		"recommendations": recommendations,
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sampleResponse)
@@ -408,13 +732,13 @@ type ApplyPatchRequest struct {
 
 func applyPatchHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "only POST allowed")
 		return
 	}
 
 	var req ApplyPatchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
 
@@ -446,33 +770,140 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 // ===================== MAIN =====================
 
 func main() {
-	addrFlag := flag.String("addr", "127.0.0.1:8080", "HTTP listen address")
-	configPath := flag.String("config", "", "path to YAML config file")
-	flag.Parse()
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runServe runs the HTTP agent. It is the default command, so existing
+// `agent -config x.yaml` invocations keep working.
+func runServe(args []string) int {
+	configureLogging(nil)
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addrFlag := fs.String("addr", "127.0.0.1:8080", "HTTP listen address (overrides server.addr)")
+	configPath := fs.String("config", "", "path to YAML config file")
+	fs.Parse(args)
 
 	if *configPath != "" {
 		cfg, err := loadConfig(*configPath)
 		if err != nil {
-			fmt.Printf("failed to load config: %v\n", err)
-			os.Exit(1)
+			slog.Error("failed to load config", "path", *configPath, "error", err)
+			return 1
+		}
+		configureLogging(cfg.Logging)
+		for _, w := range cfg.warnings {
+			slog.Warn("config warning", "problem", w)
 		}
-		globalConfig = cfg
-		fmt.Println("config loaded from", *configPath)
+		setConfig(cfg)
+		configFilePath = *configPath
+		slog.Info("config loaded", "path", *configPath)
+		watchReloadSignal()
+	} else if cfg, ok, err := configFromEnv(os.Environ()); err != nil {
+		slog.Error("failed to load config from environment", "error", err)
+		return 1
+	} else if ok {
+		configureLogging(cfg.Logging)
+		for _, w := range cfg.warnings {
+			slog.Warn("config warning", "problem", w)
+		}
+		setConfig(cfg)
+		slog.Info("config loaded from environment", "apps", len(cfg.Apps))
+	}
+
+	if cfg := getConfig(); cfg != nil && cfg.Discovery != nil {
+		startDiscovery()
 	}
+	startRetentionJanitor()
+	startVolumeMonitor()
+	startDiskWatchdog()
 
 	addr := *addrFlag
-	if globalConfig != nil && globalConfig.Server != nil && globalConfig.Server.Addr != "" && *addrFlag == ":8080" {
-		addr = globalConfig.Server.Addr
+	if cfg := getConfig(); cfg != nil && cfg.Server != nil && cfg.Server.Addr != "" && !flagSet(fs, "addr") {
+		addr = cfg.Server.Addr
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/logs", logsHandler)
 	mux.HandleFunc("/logs/analyze", logsAnalyzeHandler)
+	mux.HandleFunc("/logs/analyze/stream", logsAnalyzeStreamHandler)
 	mux.HandleFunc("/logs/apply-patch", applyPatchHandler)
+	mux.HandleFunc("/logs/profile", logsProfileHandler)
+	mux.HandleFunc("/logs/search", logsSearchHandler)
+	mux.HandleFunc("/logs/search/histogram", logsSearchHistogramHandler)
+	mux.HandleFunc("/logs/unparsed", unparsedHandler)
+	mux.HandleFunc("/logs/preflight", logsPreflightHandler)
+	mux.HandleFunc("/logs/volume", volumeHandler)
+	mux.HandleFunc("/logs/disk", diskHandler)
+	mux.HandleFunc("/baselines", baselinesHandler)
+	mux.HandleFunc("/level-overrides", levelOverridesHandler)
 	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/openapi.json", openAPIHandler)
+	mux.HandleFunc("/schemas/", schemasHandler)
+	mux.HandleFunc("/fleet/logs", fleetLogsHandler)
+	mux.HandleFunc("/config/reload", configReloadHandler)
+	mux.HandleFunc("/config/apps", configAppsHandler)
+	mux.HandleFunc("/config/apps/{name}", configAppHandler)
+	mux.HandleFunc("/config/apps/{name}/logs/{key}", configLogTargetHandler)
+	mux.HandleFunc("/admin/audit", auditHandler)
+	mux.HandleFunc("/admin/log-level", logLevelHandler)
+	registerDebugHandlers(mux)
+	registerUIHandlers(mux)
+
+	var handler http.Handler = mux
+	handler = compressMiddleware(handler)
+	handler = rateLimitMiddleware(handler)
+	handler = tenantMiddleware(handler)
+	handler = authMiddleware(handler)
+	handler = ipRateLimitMiddleware(handler)
+	handler = corsMiddleware(handler)
+	handler = ipFilterMiddleware(handler)
+	handler = auditMiddleware(handler)
+	handler = requestLogMiddleware(handler)
+	handler = requestIDMiddleware(handler)
+
+	srv := &http.Server{Addr: addr, Handler: handler}
+
+	var (
+		server   *ServerConfig
+		tlsCfg   *TLSConfig
+		timeouts *ServerTimeouts
+		maxConns int
+	)
+	if cfg := getConfig(); cfg != nil && cfg.Server != nil {
+		server = cfg.Server
+		tlsCfg = cfg.Server.TLS
+		timeouts = cfg.Server.Timeouts
+		maxConns = cfg.Server.MaxConnections
+	}
+	applyServerTimeouts(srv, timeouts)
 
-	fmt.Printf("Starting log agent on %s\n", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		fmt.Printf("server error: %v\n", err)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", addr, "error", err)
+		return 1
+	}
+	ln = newLimitListener(ln, maxConns)
+
+	if tlsCfg != nil {
+		if srv.TLSConfig, err = buildTLSConfig(tlsCfg); err != nil {
+			slog.Error("failed to configure TLS", "error", err)
+			return 1
+		}
+		slog.Info("starting log agent", "addr", addr, "tls", true)
+	} else {
+		slog.Info("starting log agent", "addr", addr, "tls", false)
+	}
+
+	err = serveGracefully(srv, server.shutdownGrace(), func() error {
+		if tlsCfg != nil {
+			return srv.ServeTLS(ln, "", "")
+		}
+		return srv.Serve(ln)
+	})
+	if err != nil {
+		slog.Error("server error", "error", err)
+		return 1
 	}
+	slog.Info("log agent stopped")
+	return 0
 }
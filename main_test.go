@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// BenchmarkFormatLogOutput measures formatting a /logs response of mixed
+// plain, error and stack trace lines into LogOutput values.
+func BenchmarkFormatLogOutput(b *testing.B) {
+	sample := strings.Join([]string{
+		"2024-05-01T12:00:00Z INFO GET /api/orders 200 12ms",
+		"2024-05-01T12:00:01Z WARN slow query took 850ms",
+		"2024-05-01T12:00:02Z ERROR java.lang.IllegalStateException: order not found",
+		"\tat com.shop.OrderService.load(OrderService.java:42)",
+		"\tat com.shop.OrderController.get(OrderController.java:17)",
+	}, "\n")
+	clean := strings.Repeat(sample+"\n", 200)
+	cfg := &Config{}
+	applyConfigDefaults(cfg)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		formatLogOutput(cfg, clean, 1<<20)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// ===================== MAXMIND DB READER =====================
+//
+
+// mmdb is a MaxMind DB file (GeoLite2/GeoIP2 .mmdb) held in memory. Only
+// what lookups need is implemented: the search tree and the data section
+// types found in the country, city and ASN databases.
+type mmdb struct {
+	tree       []byte
+	data       mmdbDecoder
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(path string) (*mmdb, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(raw, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+	}
+	metaValue, _, err := mmdbDecoder(raw[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %v", path, err)
+	}
+	meta, _ := metaValue.(map[string]interface{})
+	nodeCount, _ := meta["node_count"].(uint64)
+	recordSize, _ := meta["record_size"].(uint64)
+	ipVersion, _ := meta["ip_version"].(uint64)
+	switch {
+	case recordSize != 24 && recordSize != 28 && recordSize != 32:
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, recordSize)
+	case ipVersion != 4 && ipVersion != 6:
+		return nil, fmt.Errorf("%s: unsupported ip version %d", path, ipVersion)
+	}
+
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(i) {
+		return nil, fmt.Errorf("%s: search tree is larger than the file", path)
+	}
+	db := &mmdb{
+		tree:       raw[:treeSize],
+		data:       mmdbDecoder(raw[treeSize+16 : i]),
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+	// IPv4 addresses live under ::/96 in an IPv6 tree.
+	if db.ipVersion == 6 {
+		for range 96 {
+			if db.ipv4Start >= db.nodeCount {
+				break
+			}
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (db *mmdb) record(node, bit uint) uint {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record for ip, or nil when the database has none.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	var (
+		addr = ip.To16()
+		node uint
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		addr, node = ip4, db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(addr[i/8]>>(7-i%8))&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, fmt.Errorf("search tree ends in a node")
+	}
+	v, _, err := db.data.decode(node-db.nodeCount-16, 0)
+	return v, err
+}
+
+// mmdbDecoder decodes the data section format. Offsets, including those of
+// pointers, are relative to its start.
+type mmdbDecoder []byte
+
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBool    = 14
+	mmdbFloat   = 15
+)
+
+// decode returns the value at off and the offset just past it. Maps decode
+// to map[string]interface{}, unsigned integers to uint64 and signed ones to
+// int64.
+func (d mmdbDecoder) decode(off uint, depth int) (interface{}, uint, error) {
+	if depth > 32 {
+		return nil, 0, fmt.Errorf("data nested too deeply")
+	}
+	if off >= uint(len(d)) {
+		return nil, 0, fmt.Errorf("offset %d past the data section", off)
+	}
+	ctrl := d[off]
+	off++
+	kind := uint(ctrl >> 5)
+
+	if kind == mmdbPointer {
+		ptr, next, err := d.pointer(ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+	if kind == 0 {
+		if off >= uint(len(d)) {
+			return nil, 0, fmt.Errorf("truncated extended type")
+		}
+		kind = 7 + uint(d[off])
+		off++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > uint(len(d)) {
+			return nil, 0, fmt.Errorf("truncated size")
+		}
+		extra := uint(0)
+		for _, b := range d[off : off+n] {
+			extra = extra<<8 | uint(b)
+		}
+		off += n
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+
+	switch kind {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for range size {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			var v interface{}
+			if v, off, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, off, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for range size {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), next
+		}
+		return a, off, nil
+	case mmdbBool:
+		return size != 0, off, nil
+	}
+
+	if off+size > uint(len(d)) {
+		return nil, 0, fmt.Errorf("value at %d runs past the data section", off)
+	}
+	b := d[off : off+size]
+	off += size
+	switch kind {
+	case mmdbString:
+		return string(b), off, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), off, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128:
+		if size > 8 {
+			// Only seen in IPv6 network fields; callers never read them.
+			return append([]byte(nil), b...), off, nil
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, off, nil
+	case mmdbInt32:
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		if size < 4 && size > 0 && b[0]&0x80 != 0 {
+			u |= math.MaxUint32 << (8 * size)
+		}
+		return int64(int32(u)), off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+func (d mmdbDecoder) pointer(ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3&3) + 1
+	if off+n > uint(len(d)) {
+		return 0, 0, fmt.Errorf("truncated pointer")
+	}
+	b := d[off : off+n]
+	v := uint(ctrl & 7)
+	switch n {
+	case 1:
+		return v<<8 | uint(b[0]), off + n, nil
+	case 2:
+		return (v<<16 | uint(b[0])<<8 | uint(b[1])) + 2048, off + n, nil
+	case 3:
+		return (v<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336, off + n, nil
+	}
+	return uint(binary.BigEndian.Uint32(b)), off + n, nil
+}
+
+// mmdbRecheck is how often a loaded database file is checked for updates,
+// so a weekly GeoLite2 refresh is picked up without a restart.
+const mmdbRecheck = time.Minute
+
+type loadedMMDB struct {
+	db      *mmdb
+	err     error
+	modTime time.Time
+	checked time.Time
+}
+
+var (
+	mmdbsMu sync.Mutex
+	mmdbs   = map[string]*loadedMMDB{}
+)
+
+// loadMMDB returns the database at path, reading it again when the file
+// has changed. When a read fails the previous version is kept and
+// returned along with the error.
+func loadMMDB(path string) (*mmdb, error) {
+	mmdbsMu.Lock()
+	defer mmdbsMu.Unlock()
+
+	l := mmdbs[path]
+	if l != nil && time.Since(l.checked) < mmdbRecheck {
+		return l.db, l.err
+	}
+	if l == nil {
+		l = &loadedMMDB{}
+		mmdbs[path] = l
+	}
+	l.checked = time.Now()
+
+	info, err := os.Stat(path)
+	if err == nil && info.ModTime().Equal(l.modTime) {
+		return l.db, l.err
+	}
+	var db *mmdb
+	if err == nil {
+		db, err = openMMDB(path)
+	}
+	if l.err = err; err != nil {
+		slog.Warn("geoip database not loaded", "path", path, "error", err)
+		return l.db, err
+	}
+	l.db, l.modTime = db, info.ModTime()
+	return l.db, nil
+}
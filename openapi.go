@@ -0,0 +1,25 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//
+// ===================== OPENAPI =====================
+//
+
+// openAPISpec describes every route. Keep it in step with the handlers when
+// adding endpoints or parameters.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+//
+// ===================== /logs/preflight =====================
+//
+
+// preflightSampleLines is how many lines the sample parse reads.
+const preflightSampleLines = 100
+
+// preflightBinaryRatio is the share of control or invalid bytes above
+// which a file looks binary or mis-encoded.
+const preflightBinaryRatio = 0.01
+
+const (
+	preflightOK   = "ok"
+	preflightWarn = "warn"
+	preflightFail = "fail"
+)
+
+// PreflightCheck is one diagnostic of a preflight run. Hint says what to
+// change when the check does not pass.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// PreflightReport is what /logs/preflight returns. OK is false when any
+// check failed; checks stop at the first failure.
+type PreflightReport struct {
+	App    string           `json:"app"`
+	Log    string           `json:"log"`
+	Type   string           `json:"type"`
+	Path   string           `json:"path,omitempty"`
+	OK     bool             `json:"ok"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+func (p *PreflightReport) add(name, status, detail, hint string) bool {
+	p.Checks = append(p.Checks, PreflightCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+	if status == preflightFail {
+		p.OK = false
+	}
+	return status != preflightFail
+}
+
+// logsPreflightHandler checks that a configured target can actually be
+// read and parsed, so a wrong path or missing permission shows up before
+// an incident rather than during one.
+func logsPreflightHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	appName, logKey := q.Get("app"), q.Get("log")
+	if appName == "" || logKey == "" {
+		writeError(w, r, http.StatusBadRequest, "must provide app and log")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg != nil && !appVisible(r.Context(), cfg, appName) {
+		writeErrorFor(w, r, errNotFound(fmt.Sprintf("unknown app %q", appName)))
+		return
+	}
+	src, err := sourceFromConfig(cfg, appName, logKey)
+	var notFound errNotFound
+	if cfg == nil || errors.As(err, &notFound) {
+		writeErrorFor(w, r, err)
+		return
+	}
+
+	target := cfg.Apps[appName].Logs[logKey]
+	report := &PreflightReport{App: appName, Log: logKey, Type: target.Type, Path: target.Path, OK: true}
+	if err != nil {
+		report.add("config", preflightFail, err.Error(), "fix the target in the config and reload")
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	report.add("config", preflightOK, "target is valid", "")
+
+	local, isLocal := src.(localLogSource)
+	if isLocal && !preflightFile(report, local.LocalPath()) {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	raw, err := src.ReadLogs(r.Context(), preflightSampleLines)
+	if err != nil {
+		hint := "check read_timeout_seconds and max_read_bytes for this target"
+		if !isLocal {
+			hint = "check the URL and credentials, and that the agent can reach the service"
+		}
+		report.add("read", preflightFail, err.Error(), hint)
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	report.add("read", preflightOK, fmt.Sprintf("read %d bytes", len(raw)), "")
+	preflightParse(report, cfg, target, src, raw)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// preflightFile checks a file target's existence, permissions and
+// encoding, and reports whether reading it can go ahead.
+func preflightFile(report *PreflightReport, path string) bool {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		dir := filepath.Dir(path)
+		if _, derr := os.Stat(dir); derr != nil {
+			return report.add("exists", preflightFail, fmt.Sprintf("directory %s does not exist", dir), "check the path, or create the directory the service logs to")
+		}
+		return report.add("exists", preflightFail, "no such file", "check the file name; the service may not have written anything yet")
+	case errors.Is(err, fs.ErrPermission):
+		return report.add("exists", preflightFail, err.Error(), fmt.Sprintf("a parent directory is not searchable by the agent (%s); grant it execute permission", agentIdentity()))
+	case err != nil:
+		return report.add("exists", preflightFail, err.Error(), "")
+	case !info.Mode().IsRegular():
+		return report.add("exists", preflightFail, fmt.Sprintf("%s is not a regular file (%s)", path, info.Mode().Type()), "point path at the log file itself")
+	}
+	report.add("exists", preflightOK, fmt.Sprintf("%d bytes, modified %s", info.Size(), info.ModTime().UTC().Format("2006-01-02T15:04:05Z")), "")
+
+	f, err := os.Open(path)
+	if err != nil {
+		hint := ""
+		if errors.Is(err, fs.ErrPermission) {
+			hint = fmt.Sprintf("file is %s owned by %s but the agent runs as %s; grant it read access, e.g. through the file's group", info.Mode().Perm(), fileOwner(info), agentIdentity())
+		}
+		return report.add("readable", preflightFail, err.Error(), hint)
+	}
+	defer f.Close()
+	report.add("readable", preflightOK, fmt.Sprintf("opened as %s", agentIdentity()), "")
+
+	enc, bomLen, err := detectEncoding(f)
+	if err != nil {
+		return report.add("encoding", preflightFail, err.Error(), "")
+	}
+	name := map[textEncoding]string{encodingUTF8: "utf-8", encodingUTF16LE: "utf-16le", encodingUTF16BE: "utf-16be"}[enc]
+	if bomLen > 0 {
+		name += " with byte order mark"
+	}
+	if info.Size() == 0 {
+		report.add("encoding", preflightWarn, "file is empty", "nothing has been logged yet; re-run once the service writes")
+		return true
+	}
+	if enc == encodingUTF8 {
+		start := max(0, info.Size()-tailBlockSize)
+		sample, err := io.ReadAll(io.NewSectionReader(f, start, info.Size()-start))
+		if err != nil {
+			return report.add("encoding", preflightFail, err.Error(), "")
+		}
+		if ratio := binaryRatio(sample); ratio > preflightBinaryRatio {
+			report.add("encoding", preflightWarn, fmt.Sprintf("%s, but %.1f%% of the last %d bytes are control characters or invalid UTF-8", name, 100*ratio, len(sample)), "the file may be binary, compressed or in a legacy encoding; those bytes are dropped from responses")
+			return true
+		}
+	}
+	report.add("encoding", preflightOK, name, "")
+	return true
+}
+
+// preflightParse formats a sample the way /logs does and reports how much
+// of it the agent understands, and whether since and until can filter it.
+func preflightParse(report *PreflightReport, cfg *Config, target LogTarget, src LogSource, raw string) {
+	clean := redactLogs(cfg, target, sanitizeBinary([]byte(raw)))
+	lines := splitLogLines(clean, cfg.readSettings(report.App, report.Log).MaxLineBytes)
+	profile := profileLines(lines)
+	if profile.SampledLines == 0 {
+		report.add("parse", preflightWarn, "no lines to parse", "nothing has been logged yet; re-run once the service writes")
+		return
+	}
+
+	unparsed := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && isUnparsed(cfg.extractLine(line)) {
+			unparsed++
+		}
+	}
+	ratio := float64(unparsed) / float64(profile.SampledLines)
+	detail := fmt.Sprintf("%d lines, mostly %s, %.0f%% unparsed", profile.SampledLines, profile.Format, 100*ratio)
+	if threshold := cfg.unparsedWarnRatio(); threshold > 0 && ratio > threshold {
+		report.add("parse", preflightWarn, detail, "see /logs/profile for the line format and adjust the parser or extractors")
+	} else {
+		report.add("parse", preflightOK, detail, "")
+	}
+
+	if _, ok := src.(rangeLogSource); !ok {
+		return
+	}
+	// Check with the parser range reads use; the profile also knows
+	// layouts, such as syslog and CLF, that since and until cannot use.
+	stamped := 0
+	for _, line := range lines {
+		if _, ok := parseLogTime(line); ok {
+			stamped++
+		}
+	}
+	hint := "since and until cannot filter this target; log a timestamp such as RFC 3339 at the start of each line"
+	switch {
+	case stamped > 0:
+		report.add("timestamps", preflightOK, fmt.Sprintf("%d of %d lines have a timestamp since and until can use", stamped, profile.SampledLines), "")
+	case len(profile.TimestampLayouts) > 0:
+		report.add("timestamps", preflightWarn, fmt.Sprintf("%s timestamps are not supported by since and until", profile.TimestampLayouts[0].Name), hint)
+	default:
+		report.add("timestamps", preflightWarn, "no timestamps recognized", hint)
+	}
+}
+
+// binaryRatio is the share of bytes in data that are neither text nor
+// whitespace.
+func binaryRatio(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	bad := 0
+	for rest := data; len(rest) > 0; {
+		r, size := utf8.DecodeRune(rest)
+		if r == utf8.RuneError && size <= 1 || r < 32 && r != '\n' && r != '\r' && r != '\t' || r == 0x7f {
+			bad += size
+		}
+		rest = rest[size:]
+	}
+	return float64(bad) / float64(len(data))
+}
+
+func agentIdentity() string {
+	return fmt.Sprintf("uid %d gid %d", os.Getuid(), os.Getgid())
+}
+
+func fileOwner(info os.FileInfo) string {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("uid %d gid %d", st.Uid, st.Gid)
+	}
+	return "another user"
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// ===================== /logs/profile =====================
+//
+
+// profileSampleLines is how many lines /logs/profile reads unless lines=
+// asks for fewer (or more, up to max_lines).
+const profileSampleLines = 500
+
+// LogProfile describes what a target's lines look like, to help choose
+// parser, response and extractor settings when onboarding it.
+type LogProfile struct {
+	App          string `json:"app"`
+	Log          string `json:"log"`
+	SampledLines int    `json:"sampled_lines"`
+	// Format is the most common line format: json, logfmt or plain.
+	Format           string         `json:"format"`
+	Formats          map[string]int `json:"formats"`
+	Fields           []ProfileCount `json:"fields"`
+	TimestampLayouts []ProfileCount `json:"timestamp_layouts"`
+	Levels           map[string]int `json:"levels"`
+}
+
+// ProfileCount is a field name or timestamp layout and the number of
+// sampled lines it appeared in.
+type ProfileCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var logfmtPairRegex = regexp.MustCompile(`(?:^|\s)([A-Za-z_][\w.-]*)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+// profileLayouts are the timestamp shapes looked for anywhere in a line.
+var profileLayouts = []struct {
+	layout string
+	re     *regexp.Regexp
+}{
+	{time.RFC3339Nano, regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d+(?:Z|[+-]\d{2}:\d{2})`)},
+	{time.RFC3339, regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:Z|[+-]\d{2}:\d{2})`)},
+	{"2006-01-02 15:04:05.000", regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}[.,]\d{3}\b`)},
+	{"2006-01-02 15:04:05", regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\b`)},
+	{"2006-01-02T15:04:05", regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\b`)},
+	{"02/Jan/2006:15:04:05 -0700", regexp.MustCompile(`\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`)},
+	{time.Stamp, regexp.MustCompile(`\b[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}\b`)},
+}
+
+// profileLevelFields are the structured fields read as the line's level.
+var profileLevelFields = []string{"level", "severity", "lvl", "log.level"}
+
+func logsProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	ctx := r.Context()
+	q := r.URL.Query()
+	appName, logKey := q.Get("app"), q.Get("log")
+	if appName == "" || logKey == "" {
+		writeError(w, r, http.StatusBadRequest, "must provide app and log")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg != nil && !appVisible(ctx, cfg, appName) {
+		writeErrorFor(w, r, errNotFound(fmt.Sprintf("unknown app %q", appName)))
+		return
+	}
+	src, err := sourceFromConfig(cfg, appName, logKey)
+	if err != nil {
+		writeErrorFor(w, r, err)
+		return
+	}
+
+	settings := cfg.readSettings(appName, logKey)
+	lines := profileSampleLines
+	if q.Get("lines") != "" {
+		lines = parseLines(r, settings)
+	}
+	lines = min(lines, settings.MaxLines)
+
+	raw, err := src.ReadLogs(ctx, lines)
+	if err != nil {
+		slog.WarnContext(ctx, "log read failed", "target", readTargetKey(r), "error", err)
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to read logs: %v", err))
+		return
+	}
+	clean := redactLogs(cfg, cfg.Apps[appName].Logs[logKey], sanitizeBinary([]byte(raw)))
+
+	profile := profileLines(splitLogLines(clean, settings.MaxLineBytes))
+	profile.App, profile.Log = appName, logKey
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// profileLines classifies every non-empty line and tallies formats, fields,
+// timestamp layouts and levels.
+func profileLines(lines []string) LogProfile {
+	p := LogProfile{Formats: map[string]int{}, Levels: map[string]int{}}
+	fields := map[string]int{}
+	layouts := map[string]int{}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		p.SampledLines++
+
+		format, record := classifyLine(line)
+		p.Formats[format]++
+		for name := range record {
+			fields[name]++
+		}
+
+		for _, l := range profileLayouts {
+			if m := l.re.FindString(line); m != "" {
+				if _, err := time.Parse(l.layout, strings.Replace(m, ",", ".", 1)); err == nil {
+					layouts[l.layout]++
+					break
+				}
+			}
+		}
+
+		level := ""
+		if record != nil {
+			level, _ = fieldString(record, "", profileLevelFields)
+		}
+		if level == "" {
+			level = formatLogLine(line).Severity
+		}
+		p.Levels[normalizeLevel(level)]++
+	}
+
+	best := 0
+	for format, n := range p.Formats {
+		if n > best || (n == best && format < p.Format) {
+			p.Format, best = format, n
+		}
+	}
+	p.Fields = sortedCounts(fields)
+	p.TimestampLayouts = sortedCounts(layouts)
+	return p
+}
+
+// classifyLine reports a line's format and, for structured lines, its
+// top-level fields.
+func classifyLine(line string) (string, map[string]interface{}) {
+	if strings.HasPrefix(line, "{") {
+		var record map[string]interface{}
+		if json.Unmarshal([]byte(line), &record) == nil {
+			return "json", record
+		}
+	}
+
+	pairs := logfmtPairRegex.FindAllStringSubmatch(line, -1)
+	if len(pairs) >= 2 {
+		record := make(map[string]interface{}, len(pairs))
+		for _, kv := range pairs {
+			record[kv[1]] = strings.Trim(kv[2], `"`)
+		}
+		return "logfmt", record
+	}
+	return "plain", nil
+}
+
+func normalizeLevel(level string) string {
+	switch strings.ToUpper(level) {
+	case "":
+		return "unknown"
+	case "WARNING":
+		return "WARN"
+	case "ERR", "FATAL", "CRITICAL", "PANIC":
+		return "ERROR"
+	case "TRACE":
+		return "DEBUG"
+	default:
+		return strings.ToUpper(level)
+	}
+}
+
+func sortedCounts(counts map[string]int) []ProfileCount {
+	out := make([]ProfileCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, ProfileCount{Name: name, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== UNPARSED LINE QUARANTINE =====================
+//
+
+// unparsedSamples is how many recent unparseable lines are kept per target.
+const unparsedSamples = 20
+
+// defaultUnparsedWarnRatio is used when server.unparsed_warn_ratio is 0.
+const defaultUnparsedWarnRatio = 0.5
+
+// UnparsedStats is what /logs/unparsed reports for one target. A line is
+// unparsed when it has no leading timestamp, isn't a stack frame and isn't
+// a JSON or logfmt record; a jump in LastRatio usually means the target's
+// format changed.
+type UnparsedStats struct {
+	App       string    `json:"app"`
+	Log       string    `json:"log"`
+	Lines     int       `json:"lines"`
+	Unparsed  int       `json:"unparsed"`
+	LastRatio float64   `json:"last_ratio"`
+	LastRead  time.Time `json:"last_read"`
+	Samples   []string  `json:"samples"`
+
+	warned bool
+}
+
+var (
+	unparsedMu    sync.Mutex
+	unparsedStats = map[fanOutTarget]*UnparsedStats{}
+)
+
+func isUnparsed(out LogOutput) bool {
+	if out.Type != "" {
+		return false
+	}
+	format, _ := classifyLine(out.Raw)
+	return format == "plain"
+}
+
+// recordUnparsed tallies the unparsed lines of one read of a configured
+// target and warns once when their share crosses the threshold.
+func recordUnparsed(cfg *Config, app, key string, output interface{}) {
+	lines, ok := output.([]LogOutput)
+	if !ok || len(lines) == 0 {
+		return
+	}
+
+	var bad []string
+	for _, l := range lines {
+		if isUnparsed(l) {
+			bad = append(bad, l.Raw)
+		}
+	}
+
+	unparsedMu.Lock()
+	defer unparsedMu.Unlock()
+
+	t := fanOutTarget{app: app, key: key}
+	s, ok := unparsedStats[t]
+	if !ok {
+		s = &UnparsedStats{App: app, Log: key}
+		unparsedStats[t] = s
+	}
+	s.Lines += len(lines)
+	s.Unparsed += len(bad)
+	s.LastRatio = float64(len(bad)) / float64(len(lines))
+	s.LastRead = time.Now().UTC()
+	s.Samples = append(s.Samples, bad[max(0, len(bad)-unparsedSamples):]...)
+	s.Samples = s.Samples[max(0, len(s.Samples)-unparsedSamples):]
+
+	threshold := cfg.unparsedWarnRatio()
+	switch {
+	case threshold <= 0:
+	case s.LastRatio > threshold && !s.warned:
+		s.warned = true
+		slog.Warn("unparsed line ratio above threshold; the log format may have changed",
+			"app", app, "log", key, "ratio", s.LastRatio, "threshold", threshold)
+	case s.LastRatio <= threshold && s.warned:
+		s.warned = false
+		slog.Info("unparsed line ratio back below threshold", "app", app, "log", key, "ratio", s.LastRatio)
+	}
+}
+
+func (cfg *Config) unparsedWarnRatio() float64 {
+	if cfg == nil || cfg.Server == nil || cfg.Server.UnparsedWarnRatio == 0 {
+		return defaultUnparsedWarnRatio
+	}
+	return cfg.Server.UnparsedWarnRatio
+}
+
+// unparsedHandler lists quarantine stats for every target read so far, or
+// for one with app= and log=.
+func unparsedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	q := r.URL.Query()
+	app, key := q.Get("app"), q.Get("log")
+
+	unparsedMu.Lock()
+	out := make([]UnparsedStats, 0, len(unparsedStats))
+	for t, s := range unparsedStats {
+		if (app != "" && t.app != app) || (key != "" && t.key != key) {
+			continue
+		}
+		if cfg != nil && !appVisible(r.Context(), cfg, t.app) {
+			continue
+		}
+		c := *s
+		c.Samples = append([]string{}, s.Samples...)
+		out = append(out, c)
+	}
+	unparsedMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Compare(out[i].App+"/"+out[i].Log, out[j].App+"/"+out[j].Log) < 0
+	})
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//
+// ===================== RATE LIMITING =====================
+//
+
+type RateLimitConfig struct {
+	// RPS and Burst size a token bucket per remote IP, checked before
+	// authentication, and one per authenticated API key or JWT subject.
+	// RPS 0 disables them.
+	RPS   float64 `yaml:"rps,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+	// MaxConcurrentReads caps simultaneous reads of a single log target.
+	MaxConcurrentReads int `yaml:"max_concurrent_reads,omitempty"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type clientLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	calls   int
+}
+
+var rateLimiter = &clientLimiter{buckets: map[string]*tokenBucket{}}
+
+// idleBucketTTL is how long an untouched bucket is kept; a bucket idle that
+// long would be full again anyway.
+const idleBucketTTL = 10 * time.Minute
+
+// allow takes one token for client and, when none is left, reports how long
+// until the next one is available.
+func (l *clientLimiter) allow(client string, rps float64, burst int, now time.Time) (bool, time.Duration) {
+	if burst <= 0 {
+		burst = int(math.Ceil(rps))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls%1000 == 0 {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > idleBucketTTL {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rps * float64(time.Second))
+	return false, wait
+}
+
+// ipRateLimitMiddleware limits each remote IP before authentication, so
+// requests with guessed API keys or tokens are throttled too.
+func ipRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl := activeRateLimit(r); rl != nil {
+			if ok, wait := rateLimiter.allow("ip:"+remoteIP(r), rl.RPS, rl.Burst, time.Now()); !ok {
+				tooManyRequests(w, r, wait, "rate limit exceeded")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitMiddleware limits each authenticated principal after
+// authentication, so one key or token shared across hosts gets one
+// bucket. Principals without a name are only limited by IP.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := principalFromContext(r.Context())
+		if rl := activeRateLimit(r); rl != nil && ok && p.Name != "" {
+			if ok, wait := rateLimiter.allow("principal:"+p.Name, rl.RPS, rl.Burst, time.Now()); !ok {
+				tooManyRequests(w, r, wait, "rate limit exceeded")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// activeRateLimit returns the rate limit that applies to r, or nil.
+func activeRateLimit(r *http.Request) *RateLimitConfig {
+	cfg := getConfig()
+	if cfg == nil || cfg.Server == nil || cfg.Server.RateLimit == nil || cfg.Server.RateLimit.RPS <= 0 || r.URL.Path == "/health" {
+		return nil
+	}
+	return cfg.Server.RateLimit
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, r, http.StatusTooManyRequests, msg)
+}
+
+// ===================== PER-TARGET CONCURRENCY =====================
+
+var (
+	readSlotsMu sync.Mutex
+	readSlots   = map[string]int{}
+)
+
+// acquireReadSlot reserves one of limit concurrent read slots for target.
+// It never blocks: callers turn a refusal into a 429 so a runaway client
+// backs off instead of queueing up goroutines.
+func acquireReadSlot(target string, limit int) (release func(), ok bool) {
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	readSlotsMu.Lock()
+	defer readSlotsMu.Unlock()
+
+	if readSlots[target] >= limit {
+		return nil, false
+	}
+	readSlots[target]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			readSlotsMu.Lock()
+			readSlots[target]--
+			if readSlots[target] == 0 {
+				delete(readSlots, target)
+			}
+			readSlotsMu.Unlock()
+		})
+	}, true
+}
+
+func validateRateLimit(rl *RateLimitConfig) []string {
+	var problems []string
+	if rl.RPS < 0 {
+		problems = append(problems, "server.rate_limit.rps: must not be negative")
+	}
+	if rl.Burst < 0 {
+		problems = append(problems, "server.rate_limit.burst: must not be negative")
+	}
+	if rl.MaxConcurrentReads < 0 {
+		problems = append(problems, "server.rate_limit.max_concurrent_reads: must not be negative")
+	}
+	if rl.Burst > 0 && rl.RPS == 0 {
+		problems = append(problems, fmt.Sprintf("server.rate_limit.burst (%d) has no effect without rps", rl.Burst))
+	}
+	return problems
+}
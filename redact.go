@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//
+// ===================== PII REDACTION =====================
+//
+
+// RedactionConfig turns on masking of sensitive values in log output.
+// Masked values become <kind:hash>, where hash is a keyed digest of the
+// original, so the same email or IP masks to the same token across lines and
+// requests and can still be correlated.
+type RedactionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Detectors selects built-in detectors; empty means all of them.
+	Detectors []string `yaml:"detectors,omitempty"`
+	// HashKey keys the digest. When empty a random per-process key is used,
+	// so tokens are only stable until the agent restarts.
+	HashKey string `yaml:"hash_key,omitempty"`
+}
+
+// RedactRule is a custom per-target pattern; every match is masked as
+// <name:hash>. Rules apply even with redaction.enabled off, which only
+// switches the built-in detectors.
+type RedactRule struct {
+	Name    string `yaml:"name" json:"name"`
+	Pattern string `yaml:"pattern" json:"pattern"`
+}
+
+type redactDetector struct {
+	re *regexp.Regexp
+	// keep, if set, decides whether a candidate match is really sensitive.
+	keep func(match string) bool
+	// prefixGroup, when > 0, is a capture group left in clear text (e.g.
+	// the "Bearer " in front of a token).
+	prefixGroup int
+}
+
+var builtinDetectors = map[string]redactDetector{
+	"bearer_token": {
+		re:          regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+		prefixGroup: 1,
+	},
+	"jwt": {
+		re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	},
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"credit_card": {
+		re:   regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		keep: luhnValid,
+	},
+	"ip": {
+		re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?i)\b(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{1,4}\b`),
+		keep: func(m string) bool {
+			return net.ParseIP(m) != nil
+		},
+	},
+}
+
+// builtinDetectorOrder runs token detectors before email/ip so an address
+// embedded in a token is masked as part of the token.
+var builtinDetectorOrder = []string{"bearer_token", "jwt", "email", "credit_card", "ip"}
+
+var (
+	processHashKeyOnce sync.Once
+	processHashKey     []byte
+
+	customRedactRegexes sync.Map // pattern -> *regexp.Regexp
+)
+
+func redactionHashKey(c *RedactionConfig) []byte {
+	if c != nil && c.HashKey != "" {
+		return []byte(c.HashKey)
+	}
+	processHashKeyOnce.Do(func() {
+		processHashKey = make([]byte, 32)
+		rand.Read(processHashKey)
+	})
+	return processHashKey
+}
+
+// redactLogs masks sensitive values in text using the custom rules of
+// target, which always apply, plus the built-in detectors when redaction is
+// enabled globally.
+func redactLogs(cfg *Config, target LogTarget, text string) string {
+	var redaction *RedactionConfig
+	if cfg != nil {
+		redaction = cfg.Redaction
+	}
+	global := redaction != nil && redaction.Enabled
+	if !global && len(target.Redact) == 0 {
+		return text
+	}
+	key := redactionHashKey(redaction)
+
+	for _, rule := range target.Redact {
+		re, err := customRedactRegex(rule.Pattern)
+		if err != nil {
+			continue
+		}
+		text = maskMatches(text, rule.Name, redactDetector{re: re}, key)
+	}
+	if !global {
+		return text
+	}
+
+	names := redaction.Detectors
+	if len(names) == 0 {
+		names = builtinDetectorOrder
+	}
+	for _, name := range builtinDetectorOrder {
+		if !containsString(names, name) {
+			continue
+		}
+		text = maskMatches(text, name, builtinDetectors[name], key)
+	}
+	return text
+}
+
+func maskMatches(text, kind string, d redactDetector, key []byte) string {
+	return d.re.ReplaceAllStringFunc(text, func(match string) string {
+		prefix := ""
+		value := match
+		if d.prefixGroup > 0 {
+			sub := d.re.FindStringSubmatch(match)
+			prefix = sub[d.prefixGroup]
+			value = match[len(prefix):]
+		}
+		if d.keep != nil && !d.keep(value) {
+			return match
+		}
+		return prefix + maskToken(kind, value, key)
+	})
+}
+
+func maskToken(kind, value string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("<%s:%s>", kind, hex.EncodeToString(mac.Sum(nil)[:4]))
+}
+
+func customRedactRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := customRedactRegexes.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	customRedactRegexes.Store(pattern, re)
+	return re, nil
+}
+
+// luhnValid filters digit runs down to plausible card numbers, so order IDs
+// and timestamps aren't masked as cards.
+func luhnValid(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRedaction(c *RedactionConfig) []string {
+	var problems []string
+	for _, name := range c.Detectors {
+		if _, ok := builtinDetectors[name]; !ok {
+			problems = append(problems, fmt.Sprintf("redaction.detectors: unknown detector %q (expected one of %s)", name, strings.Join(builtinDetectorOrder, ", ")))
+		}
+	}
+	return problems
+}
+
+func validateRedactRules(field string, rules []RedactRule) []string {
+	var problems []string
+	for i, rule := range rules {
+		if rule.Name == "" {
+			problems = append(problems, fmt.Sprintf("%s.redact[%d]: missing name", field, i))
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.redact[%d].pattern: %v", field, i, err))
+		}
+	}
+	return problems
+}
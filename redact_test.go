@@ -0,0 +1,82 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var maskRegex = regexp.MustCompile(`<([a-z_]+):[0-9a-f]{8}>`)
+
+func TestRedactLogs(t *testing.T) {
+	cfg := &Config{Redaction: &RedactionConfig{Enabled: true, HashKey: "test-key"}}
+
+	tests := []struct {
+		name     string
+		cfg      *Config
+		target   LogTarget
+		in       string
+		wantKind []string
+		wantKeep []string
+	}{
+		{"email", cfg, LogTarget{}, "user alice@example.com logged in", []string{"email"}, []string{"user ", " logged in"}},
+		{"valid card", cfg, LogTarget{}, "paid with 4111 1111 1111 1111", []string{"credit_card"}, []string{"paid with "}},
+		{"luhn invalid digits", cfg, LogTarget{}, "order 1234567890123456 shipped", nil, []string{"1234567890123456"}},
+		{"bearer keeps prefix", cfg, LogTarget{}, "Authorization: Bearer abc.def-123", []string{"bearer_token"}, []string{"Bearer "}},
+		{"ip", cfg, LogTarget{}, "from 10.1.2.3", []string{"ip"}, nil},
+		{"not an ip", cfg, LogTarget{}, "version 999.1.2.3", nil, []string{"999.1.2.3"}},
+		{"detector subset", &Config{Redaction: &RedactionConfig{Enabled: true, Detectors: []string{"ip"}}}, LogTarget{}, "alice@example.com from 10.1.2.3", []string{"ip"}, []string{"alice@example.com"}},
+		{"disabled", &Config{Redaction: &RedactionConfig{}}, LogTarget{}, "alice@example.com", nil, []string{"alice@example.com"}},
+		{"target rule without global redaction", &Config{}, LogTarget{Redact: []RedactRule{{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`}}}, "ssn 123-45-6789 for alice@example.com", []string{"ssn"}, []string{"alice@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactLogs(tt.cfg, tt.target, tt.in)
+			var kinds []string
+			for _, m := range maskRegex.FindAllStringSubmatch(got, -1) {
+				kinds = append(kinds, m[1])
+			}
+			if strings.Join(kinds, ",") != strings.Join(tt.wantKind, ",") {
+				t.Fatalf("redactLogs(%q) = %q, masked %v, want %v", tt.in, got, kinds, tt.wantKind)
+			}
+			for _, keep := range tt.wantKeep {
+				if !strings.Contains(got, keep) {
+					t.Fatalf("redactLogs(%q) = %q, want %q kept", tt.in, got, keep)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactLogsHashStability(t *testing.T) {
+	cfg := &Config{Redaction: &RedactionConfig{Enabled: true, HashKey: "k1"}}
+	first := redactLogs(cfg, LogTarget{}, "alice@example.com")
+	if again := redactLogs(cfg, LogTarget{}, "login by alice@example.com"); !strings.Contains(again, first) {
+		t.Fatalf("same value masked as %q and %q, want the same token", first, again)
+	}
+	if other := redactLogs(cfg, LogTarget{}, "bob@example.com"); other == first {
+		t.Fatalf("different values both masked as %q", first)
+	}
+	rekeyed := redactLogs(&Config{Redaction: &RedactionConfig{Enabled: true, HashKey: "k2"}}, LogTarget{}, "alice@example.com")
+	if rekeyed == first {
+		t.Fatalf("hash_key change kept the token %q", first)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"4111111111111111", true},
+		{"4111-1111-1111-1111", true},
+		{"4111111111111112", false},
+		{"411111111111", false},
+		{"12345678901234567890", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.in); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
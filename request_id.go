@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+//
+// ===================== REQUEST IDS =====================
+//
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits caller-supplied IDs to something safe to echo into
+// headers and logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// traceParentKey carries the caller's W3C traceparent so source reads can
+// forward it and downstream services join the same trace.
+type traceParentKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware adopts the caller's X-Request-ID (or assigns one),
+// echoes it on the response and stores it in the request context, where
+// self-logs, audit entries and outgoing source requests pick it up.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		if tp := r.Header.Get("Traceparent"); tp != "" {
+			ctx = context.WithValue(ctx, traceParentKey{}, tp)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// setTraceHeaders forwards the request ID and trace context to a downstream
+// request made on behalf of ctx.
+func setTraceHeaders(ctx context.Context, req *http.Request) {
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if tp, _ := ctx.Value(traceParentKey{}).(string); tp != "" {
+		req.Header.Set("Traceparent", tp)
+	}
+}
+
+// requestIDHandler adds request_id to every record logged with a request
+// context (slog.InfoContext and friends).
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+//
+// ===================== RETRY & CIRCUIT BREAKER =====================
+//
+
+// RetryConfig tunes how outbound calls (api sources) are retried. Zero
+// values use the defaults in retryPolicy.
+type RetryConfig struct {
+	// MaxAttempts includes the first try; 1 disables retries.
+	MaxAttempts      int `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`
+	InitialBackoffMS int `yaml:"initial_backoff_ms,omitempty" json:"initial_backoff_ms,omitempty"`
+	MaxBackoffMS     int `yaml:"max_backoff_ms,omitempty" json:"max_backoff_ms,omitempty"`
+	// BreakerThreshold consecutive failures open the circuit for
+	// BreakerCooldownSeconds, during which calls fail fast.
+	BreakerThreshold       int `yaml:"breaker_threshold,omitempty" json:"breaker_threshold,omitempty"`
+	BreakerCooldownSeconds int `yaml:"breaker_cooldown_seconds,omitempty" json:"breaker_cooldown_seconds,omitempty"`
+}
+
+type retryPolicy struct {
+	maxAttempts      int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+func (c *RetryConfig) policy() retryPolicy {
+	p := retryPolicy{
+		maxAttempts:      3,
+		initialBackoff:   200 * time.Millisecond,
+		maxBackoff:       2 * time.Second,
+		breakerThreshold: 5,
+		breakerCooldown:  30 * time.Second,
+	}
+	if c == nil {
+		return p
+	}
+	if c.MaxAttempts > 0 {
+		p.maxAttempts = c.MaxAttempts
+	}
+	if c.InitialBackoffMS > 0 {
+		p.initialBackoff = time.Duration(c.InitialBackoffMS) * time.Millisecond
+	}
+	if c.MaxBackoffMS > 0 {
+		p.maxBackoff = time.Duration(c.MaxBackoffMS) * time.Millisecond
+	}
+	if c.BreakerThreshold > 0 {
+		p.breakerThreshold = c.BreakerThreshold
+	}
+	if c.BreakerCooldownSeconds > 0 {
+		p.breakerCooldown = time.Duration(c.BreakerCooldownSeconds) * time.Second
+	}
+	return p
+}
+
+// outboundStats counts outbound calls per outcome; it shows up in
+// /debug/vars.
+var outboundStats = expvar.NewMap("outbound")
+
+// errRetryable marks a failure worth another attempt (network errors, 5xx,
+// 429). Anything else is returned immediately.
+type errRetryable struct{ error }
+
+func (e errRetryable) Unwrap() error { return e.error }
+
+var errCircuitOpen = errors.New("circuit open after repeated failures; not calling target")
+
+// withRetry runs fn under the policy's retries and the circuit breaker for
+// key. Backoff is exponential with full jitter, and retries also draw on a
+// shared budget so a widespread outage doesn't multiply load on upstreams.
+func withRetry(ctx context.Context, key string, p retryPolicy, fn func() error) error {
+	b := breakerFor(key)
+	if !b.allow(p) {
+		outboundStats.Add("breaker_rejected", 1)
+		return errCircuitOpen
+	}
+	outboundStats.Add("requests", 1)
+	retryBudget.deposit()
+
+	backoff := p.initialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil {
+			b.success()
+			return nil
+		}
+
+		var retryable errRetryable
+		if !errors.As(err, &retryable) || attempt >= p.maxAttempts || !retryBudget.withdraw() {
+			break
+		}
+
+		outboundStats.Add("retries", 1)
+		delay := time.Duration(rand.Int64N(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			b.failure(p)
+			outboundStats.Add("failures", 1)
+			return err
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, p.maxBackoff)
+	}
+
+	b.failure(p)
+	outboundStats.Add("failures", 1)
+	return err
+}
+
+// retryBudget allows roughly one retry per five requests, plus a small
+// burst, across all targets.
+var retryBudget = &tokenBudget{tokens: 10, max: 10, perRequest: 0.2}
+
+type tokenBudget struct {
+	mu         sync.Mutex
+	tokens     float64
+	max        float64
+	perRequest float64
+}
+
+func (t *tokenBudget) deposit() {
+	t.mu.Lock()
+	t.tokens = min(t.tokens+t.perRequest, t.max)
+	t.mu.Unlock()
+}
+
+func (t *tokenBudget) withdraw() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*circuitBreaker{}
+)
+
+func breakerFor(key string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[key]
+	if !ok {
+		b = &circuitBreaker{}
+		breakers[key] = b
+	}
+	return b
+}
+
+// allow lets calls through while closed. Once the cooldown of an open
+// breaker has passed, a single probe goes through; its outcome closes or
+// re-opens the breaker.
+func (b *circuitBreaker) allow(p retryPolicy) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < p.breakerThreshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	b.failures, b.probing = 0, false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) failure(p retryPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= p.breakerThreshold {
+		b.openUntil = time.Now().Add(p.breakerCooldown)
+	}
+}
+
+func validateRetryConfig(field string, c *RetryConfig) []string {
+	var problems []string
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"max_attempts", c.MaxAttempts},
+		{"initial_backoff_ms", c.InitialBackoffMS},
+		{"max_backoff_ms", c.MaxBackoffMS},
+		{"breaker_threshold", c.BreakerThreshold},
+		{"breaker_cooldown_seconds", c.BreakerCooldownSeconds},
+	} {
+		if f.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s.retry.%s: must not be negative", field, f.name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+//
+// ===================== JSON SCHEMAS =====================
+//
+
+// outputSchemaVersion is bumped whenever a response payload changes in a way
+// that could break a consumer validating against /schemas/.
+const outputSchemaVersion = 1
+
+//go:embed schemas
+var schemaFiles embed.FS
+
+type SchemaIndex struct {
+	SchemaVersion int      `json:"schema_version"`
+	Schemas       []string `json:"schemas"`
+}
+
+// schemasHandler lists the JSON Schemas for response payloads at /schemas/
+// and serves each one at /schemas/<name>.json.
+func schemasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/schemas/")
+	if name == "" {
+		entries, _ := schemaFiles.ReadDir("schemas")
+		index := SchemaIndex{SchemaVersion: outputSchemaVersion, Schemas: []string{}}
+		for _, e := range entries {
+			index.Schemas = append(index.Schemas, e.Name())
+		}
+		sort.Strings(index.Schemas)
+		writeJSON(w, http.StatusOK, index)
+		return
+	}
+
+	data, err := schemaFiles.ReadFile(path.Join("schemas", path.Base(name)))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "unknown schema "+name)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(data)
+}
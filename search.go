@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ===================== /logs/search =====================
+//
+
+// A search query is a space-separated list of terms that must all match,
+// for example
+//
+//	level:ERROR service:payments "timeout" -path:/health since:-15m
+//
+// A bare word or "quoted phrase" matches anywhere in the line, ignoring
+// case. field:value matches level (or severity), status, latency, type,
+// class (error_class), any top-level field of a JSON or logfmt line, or
+// else a client detail (client_ip, country, asn, as_org, ua, ua_version)
+// or label; a value ending in * matches by prefix, status takes a class
+// such as 5xx, and >, >=, < and <= compare numbers (status:>=500,
+// latency:>250). A leading - negates a term. since: and until: bound the
+// read with any time parseTimeParam accepts (RFC 3339, Unix, now-1h,
+// -15m), and app: and log: pick the targets, * included, instead of app=
+// and log=.
+type searchQuery struct {
+	terms        []searchTerm
+	since, until time.Time
+	app, log     string
+}
+
+type searchTerm struct {
+	field  string // "" for free text
+	value  string
+	op     string // "", ">", ">=", "<" or "<="
+	negate bool
+}
+
+// SearchResponse is the /logs/search body. Matches holds at most lines=
+// of the most recent matching lines per target; Scanned counts every line
+// the query was tried against.
+type SearchResponse struct {
+	Query   string        `json:"query"`
+	Since   *time.Time    `json:"since,omitempty"`
+	Until   *time.Time    `json:"until,omitempty"`
+	Scanned int           `json:"scanned"`
+	Matches []SearchMatch `json:"matches"`
+	Errors  []SearchError `json:"errors,omitempty"`
+}
+
+type SearchMatch struct {
+	App string `json:"app"`
+	Log string `json:"log"`
+	LogOutput
+
+	at time.Time // zero when no line up to this one carried a timestamp
+}
+
+// SearchError reports a target that could not be searched; the others are
+// still returned.
+type SearchError struct {
+	App   string `json:"app"`
+	Log   string `json:"log"`
+	Error string `json:"error"`
+}
+
+// parseSearchQuery parses q relative to now.
+func parseSearchQuery(q string, now time.Time) (searchQuery, error) {
+	var sq searchQuery
+	tokens, err := splitSearchTokens(q)
+	if err != nil {
+		return sq, err
+	}
+
+	for _, tok := range tokens {
+		var t searchTerm
+		if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+			t.negate, tok = true, tok[1:]
+		}
+		if field, value, ok := strings.Cut(tok, ":"); ok && field != "" && !strings.HasPrefix(field, `"`) {
+			t.field, t.value = strings.ToLower(field), unquoteSearch(value)
+		} else {
+			t.value = unquoteSearch(tok)
+		}
+		if t.value == "" {
+			return sq, fmt.Errorf("empty value in %q", tok)
+		}
+
+		switch t.field {
+		case "since", "until", "app", "log":
+			if t.negate {
+				return sq, fmt.Errorf("%s: cannot be negated", t.field)
+			}
+		}
+		switch t.field {
+		case "since", "until":
+			at, err := parseTimeParam(t.value, now)
+			if err != nil {
+				return sq, fmt.Errorf("%s: %v", t.field, err)
+			}
+			if t.field == "since" {
+				sq.since = at
+			} else {
+				sq.until = at
+			}
+			continue
+		case "app":
+			sq.app = t.value
+			continue
+		case "log":
+			sq.log = t.value
+			continue
+		case "severity":
+			t.field = "level"
+		case "latency_ms":
+			t.field = "latency"
+		case "http_status":
+			t.field = "status"
+		}
+
+		if t.field != "" {
+			for _, op := range []string{">=", "<=", ">", "<"} {
+				if rest, ok := strings.CutPrefix(t.value, op); ok {
+					if _, err := strconv.ParseFloat(rest, 64); err != nil {
+						return sq, fmt.Errorf("%s: %q is not a number", t.field, rest)
+					}
+					t.op, t.value = op, rest
+					break
+				}
+			}
+		}
+		if t.field == "latency" && t.op == "" {
+			return sq, fmt.Errorf("latency: needs a comparison such as latency:>250")
+		}
+		sq.terms = append(sq.terms, t)
+	}
+
+	if !sq.since.IsZero() && !sq.until.IsZero() && !sq.until.After(sq.since) {
+		return sq, fmt.Errorf("until must be after since")
+	}
+	return sq, nil
+}
+
+// splitSearchTokens splits on spaces outside double quotes.
+func splitSearchTokens(q string) ([]string, error) {
+	var (
+		tokens  []string
+		cur     strings.Builder
+		inQuote bool
+	)
+	for _, c := range q {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			cur.WriteRune(c)
+		case (c == ' ' || c == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+func unquoteSearch(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// matches reports whether every term matches line.
+func (sq searchQuery) matches(line LogOutput) bool {
+	var (
+		record map[string]interface{}
+		parsed bool
+	)
+	fields := func() map[string]interface{} {
+		if !parsed {
+			_, record = classifyLine(line.Raw)
+			parsed = true
+		}
+		return record
+	}
+	for _, t := range sq.terms {
+		if t.match(line, fields) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t searchTerm) match(line LogOutput, fields func() map[string]interface{}) bool {
+	switch t.field {
+	case "":
+		return strings.Contains(strings.ToLower(line.Raw), strings.ToLower(t.value))
+	case "level":
+		level, _ := fieldString(fields(), "", profileLevelFields)
+		if level == "" {
+			level = line.Severity
+		}
+		return normalizeLevel(level) == normalizeLevel(t.value)
+	case "status":
+		if line.HTTPStatus == 0 {
+			return false
+		}
+		if class, ok := strings.CutSuffix(strings.ToLower(t.value), "xx"); ok && t.op == "" {
+			return strconv.Itoa(line.HTTPStatus/100) == class
+		}
+		return t.compare(strconv.Itoa(line.HTTPStatus))
+	case "latency":
+		return line.LatencyMS != nil && t.compare(strconv.FormatFloat(*line.LatencyMS, 'f', -1, 64))
+	case "type":
+		return strings.EqualFold(line.Type, t.value)
+	case "class", "error_class":
+		return line.ErrorClass != "" && t.compare(line.ErrorClass)
+	}
+
+	record := fields()
+	v, ok := record[t.field]
+	if !ok {
+		v, ok = lookupPath(record, t.field)
+	}
+	if ok {
+		s, isString := v.(string)
+		if !isString {
+			data, _ := json.Marshal(v)
+			s = string(data)
+		}
+		return t.compare(s)
+	}
+
+	// Fields of the line itself win over client details and labels.
+	if c := line.Client; c != nil {
+		switch t.field {
+		case "client_ip":
+			return t.compare(c.IP)
+		case "country":
+			return t.compare(c.Country)
+		case "asn":
+			return c.ASN != 0 && t.compare(strconv.FormatUint(c.ASN, 10))
+		case "as_org":
+			return t.compare(c.ASOrg)
+		case "ua", "user_agent":
+			return t.compare(c.UserAgent)
+		case "ua_version", "user_agent_version":
+			return t.compare(c.UserAgentVersion)
+		}
+	}
+	label, ok := line.Labels[t.field]
+	return ok && t.compare(label)
+}
+
+// compare matches s against the term's value: numerically for >, >=, <
+// and <=, by prefix for a trailing *, otherwise case-insensitively whole.
+func (t searchTerm) compare(s string) bool {
+	if t.op != "" {
+		got, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false
+		}
+		want, _ := strconv.ParseFloat(t.value, 64)
+		switch t.op {
+		case ">":
+			return got > want
+		case ">=":
+			return got >= want
+		case "<":
+			return got < want
+		default:
+			return got <= want
+		}
+	}
+	if prefix, ok := strings.CutSuffix(t.value, "*"); ok {
+		return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return strings.EqualFold(s, t.value)
+}
+
+// logsSearchHandler runs a query over the last max_lines of each selected
+// target and returns the matching lines.
+func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil {
+		writeError(w, r, http.StatusBadRequest, "config not loaded; start server with -config flag")
+		return
+	}
+	run, err := runSearch(r, cfg)
+	if err != nil {
+		writeErrorFor(w, r, err)
+		return
+	}
+
+	res := SearchResponse{Query: run.query, Since: optionalTime(run.since), Until: optionalTime(run.until), Matches: []SearchMatch{}}
+	for _, tr := range run.results {
+		res.Scanned += tr.scanned
+		matches := tr.matches
+		if n := parseLines(r, cfg.readSettings(tr.app, tr.key)); len(matches) > n {
+			matches = matches[len(matches)-n:]
+		}
+		res.Matches = append(res.Matches, matches...)
+		if tr.err != "" {
+			res.Errors = append(res.Errors, SearchError{App: tr.app, Log: tr.key, Error: tr.err})
+		}
+	}
+	writeNegotiated(w, r, http.StatusOK, res)
+}
+
+type searchRun struct {
+	query        string
+	since, until time.Time
+	results      []targetSearch
+}
+
+// runSearch parses the q, app, log, since and until parameters of r and
+// searches the selected targets. Terms in q win over the parameters.
+func runSearch(r *http.Request, cfg *Config) (searchRun, error) {
+	q := r.URL.Query()
+	run := searchRun{query: q.Get("q")}
+	sq, err := parseSearchQuery(run.query, time.Now())
+	if err != nil {
+		return run, fmt.Errorf("invalid query: %v", err)
+	}
+	if run.since, run.until, err = parseTimeRange(r); err != nil {
+		return run, err
+	}
+	if !sq.since.IsZero() {
+		run.since = sq.since
+	}
+	if !sq.until.IsZero() {
+		run.until = sq.until
+	}
+
+	appName, logKey := defaultString(sq.app, q.Get("app")), defaultString(sq.log, q.Get("log"))
+	targets := slices.DeleteFunc(fanOutTargets(cfg, defaultString(appName, fanOutWildcard), defaultString(logKey, fanOutWildcard)), func(t fanOutTarget) bool {
+		return !appVisible(r.Context(), cfg, t.app)
+	})
+	if len(targets) == 0 {
+		return run, errNotFound(fmt.Sprintf("no log targets match app=%s log=%s", appName, logKey))
+	}
+	run.results = searchTargets(r, cfg, targets, sq, run.since, run.until)
+	return run, nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+type targetSearch struct {
+	fanOutTarget
+	scanned int
+	matches []SearchMatch
+	err     string
+}
+
+// searchTargets reads the targets with the fan-out worker and timeout
+// limits and filters each one's lines, keeping target order.
+func searchTargets(r *http.Request, cfg *Config, targets []fanOutTarget, sq searchQuery, since, until time.Time) []targetSearch {
+	var fanOut *FanOutConfig
+	if cfg.Server != nil {
+		fanOut = cfg.Server.FanOut
+	}
+
+	out := make([]targetSearch, len(targets))
+	sem := make(chan struct{}, fanOut.workers())
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(r.Context(), fanOut.timeout())
+			defer cancel()
+			out[i] = searchTarget(ctx, cfg, t, sq, since, until)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// searchTarget returns every matching line of one target. Each match is
+// stamped with its own leading or JSON timestamp, or else with that of the
+// closest stamped line above it.
+func searchTarget(ctx context.Context, cfg *Config, t fanOutTarget, sq searchQuery, since, until time.Time) targetSearch {
+	res := targetSearch{fanOutTarget: t}
+
+	logs, _, err := readTarget(ctx, cfg, t, cfg.readSettings(t.app, t.key).MaxLines, since, until)
+	if err != nil {
+		res.err = err.Error()
+		return res
+	}
+	lines, ok := logs.([]LogOutput)
+	if !ok {
+		res.err = "target returns a JSON document, not log lines"
+		return res
+	}
+
+	res.scanned = len(lines)
+	offset := cfg.clockOffset(ctx, t.app, t.key)
+	var stamp time.Time
+	for _, l := range lines {
+		if at, ok := parseLogTime(l.Raw); ok {
+			stamp = at.Add(offset)
+		}
+		if sq.matches(l) {
+			res.matches = append(res.matches, SearchMatch{App: t.app, Log: t.key, LogOutput: l, at: stamp})
+		}
+	}
+	return res
+}
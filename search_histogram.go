@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//
+// ===================== /logs/search/histogram =====================
+//
+
+// maxHistogramBuckets bounds the buckets one histogram may return.
+const maxHistogramBuckets = 1000
+
+// SearchHistogram counts the matches of a /logs/search query per interval.
+// Buckets run from since (or the first match) to until (or now when since
+// is set, else the last match), empty ones included. Matches with no
+// timestamp on or above their line are only counted in Unstamped.
+type SearchHistogram struct {
+	Query     string            `json:"query"`
+	Since     *time.Time        `json:"since,omitempty"`
+	Until     *time.Time        `json:"until,omitempty"`
+	Interval  string            `json:"interval"`
+	Scanned   int               `json:"scanned"`
+	Matched   int               `json:"matched"`
+	Unstamped int               `json:"unstamped,omitempty"`
+	Buckets   []HistogramBucket `json:"buckets"`
+	Errors    []SearchError     `json:"errors,omitempty"`
+}
+
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+func logsSearchHistogramHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil {
+		writeError(w, r, http.StatusBadRequest, "config not loaded; start server with -config flag")
+		return
+	}
+
+	interval := time.Minute
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			writeError(w, r, http.StatusBadRequest, "invalid 'interval': expected a duration of at least 1s, such as 30s or 5m")
+			return
+		}
+		interval = d
+	}
+
+	run, err := runSearch(r, cfg)
+	if err != nil {
+		writeErrorFor(w, r, err)
+		return
+	}
+
+	res := SearchHistogram{
+		Query:    run.query,
+		Since:    optionalTime(run.since),
+		Until:    optionalTime(run.until),
+		Interval: interval.String(),
+		Buckets:  []HistogramBucket{},
+	}
+	var stamps []time.Time
+	for _, tr := range run.results {
+		res.Scanned += tr.scanned
+		res.Matched += len(tr.matches)
+		for _, m := range tr.matches {
+			if m.at.IsZero() {
+				res.Unstamped++
+			} else {
+				stamps = append(stamps, m.at)
+			}
+		}
+		if tr.err != "" {
+			res.Errors = append(res.Errors, SearchError{App: tr.app, Log: tr.key, Error: tr.err})
+		}
+	}
+
+	start, end := run.since, run.until
+	for _, at := range stamps {
+		if run.since.IsZero() && (start.IsZero() || at.Before(start)) {
+			start = at
+		}
+		if run.until.IsZero() && at.After(end) {
+			end = at
+		}
+	}
+	if run.until.IsZero() && !run.since.IsZero() {
+		end = time.Now()
+	}
+	if start.IsZero() || end.IsZero() {
+		writeNegotiated(w, r, http.StatusOK, res)
+		return
+	}
+
+	start = start.Truncate(interval).UTC()
+	n := int(end.Sub(start)/interval) + 1
+	if n > maxHistogramBuckets {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("interval %s gives %d buckets for this range; at most %d are allowed", interval, n, maxHistogramBuckets))
+		return
+	}
+	res.Buckets = make([]HistogramBucket, n)
+	for i := range res.Buckets {
+		res.Buckets[i].Start = start.Add(time.Duration(i) * interval)
+	}
+	for _, at := range stamps {
+		if i := int(at.Sub(start) / interval); i >= 0 && i < n {
+			res.Buckets[i].Count++
+		}
+	}
+	writeNegotiated(w, r, http.StatusOK, res)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		q         string
+		wantTerms []searchTerm
+		wantSince time.Time
+		wantUntil time.Time
+		wantApp   string
+		wantLog   string
+		wantErr   string
+	}{
+		{q: "", wantTerms: nil},
+		{q: "timeout", wantTerms: []searchTerm{{value: "timeout"}}},
+		{q: `"connection reset" -debug`, wantTerms: []searchTerm{{value: "connection reset"}, {value: "debug", negate: true}}},
+		{q: "Level:ERROR service:pay*", wantTerms: []searchTerm{{field: "level", value: "ERROR"}, {field: "service", value: "pay*"}}},
+		{q: "severity:warn http_status:5xx", wantTerms: []searchTerm{{field: "level", value: "warn"}, {field: "status", value: "5xx"}}},
+		{q: "status:>=500 latency_ms:>250", wantTerms: []searchTerm{{field: "status", value: "500", op: ">="}, {field: "latency", value: "250", op: ">"}}},
+		{q: `-path:/health msg:"a b"`, wantTerms: []searchTerm{{field: "path", value: "/health", negate: true}, {field: "msg", value: "a b"}}},
+		{q: `"key:value"`, wantTerms: []searchTerm{{value: "key:value"}}},
+		{q: "since:-15m until:now app:web log:*", wantSince: now.Add(-15 * time.Minute), wantUntil: now, wantApp: "web", wantLog: "*"},
+		{q: "-", wantTerms: []searchTerm{{value: "-"}}},
+		{q: `"open`, wantErr: "unterminated quote"},
+		{q: "level:", wantErr: "empty value"},
+		{q: "-app:web", wantErr: "cannot be negated"},
+		{q: "since:soon", wantErr: "since:"},
+		{q: "status:>abc", wantErr: "not a number"},
+		{q: "latency:250", wantErr: "needs a comparison"},
+		{q: "since:-5m until:-10m", wantErr: "until must be after since"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			sq, err := parseSearchQuery(tt.q, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseSearchQuery(%q) error = %v, want it to mention %q", tt.q, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSearchQuery(%q) error = %v", tt.q, err)
+			}
+			if !reflect.DeepEqual(sq.terms, tt.wantTerms) {
+				t.Fatalf("parseSearchQuery(%q) terms = %+v, want %+v", tt.q, sq.terms, tt.wantTerms)
+			}
+			if !sq.since.Equal(tt.wantSince) || !sq.until.Equal(tt.wantUntil) || sq.app != tt.wantApp || sq.log != tt.wantLog {
+				t.Fatalf("parseSearchQuery(%q) = since %v until %v app %q log %q", tt.q, sq.since, sq.until, sq.app, sq.log)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//
+// ===================== SERVER TIMEOUTS & CONNECTION LIMITS =====================
+//
+
+// ServerTimeouts bound how long a client may hold a connection. Zero values
+// use the defaults below; a slow client can otherwise pin a connection open
+// forever (slowloris).
+type ServerTimeouts struct {
+	ReadHeaderSeconds int `yaml:"read_header_seconds,omitempty"`
+	ReadSeconds       int `yaml:"read_seconds,omitempty"`
+	// WriteSeconds caps the whole response. Keep it above the slowest
+	// api target's timeout_seconds.
+	WriteSeconds int `yaml:"write_seconds,omitempty"`
+	IdleSeconds  int `yaml:"idle_seconds,omitempty"`
+}
+
+func secondsOr(v, def int) time.Duration {
+	if v <= 0 {
+		return time.Duration(def) * time.Second
+	}
+	return time.Duration(v) * time.Second
+}
+
+// applyServerTimeouts sets the http.Server timeouts from config.
+func applyServerTimeouts(srv *http.Server, t *ServerTimeouts) {
+	if t == nil {
+		t = &ServerTimeouts{}
+	}
+	srv.ReadHeaderTimeout = secondsOr(t.ReadHeaderSeconds, 10)
+	srv.ReadTimeout = secondsOr(t.ReadSeconds, 30)
+	srv.WriteTimeout = secondsOr(t.WriteSeconds, 60)
+	srv.IdleTimeout = secondsOr(t.IdleSeconds, 120)
+}
+
+// limitListener caps the number of open connections. Accept blocks once the
+// limit is reached, so excess clients wait in the kernel backlog instead of
+// costing the agent a goroutine and buffers each.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return l
+	}
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+func validateServerTimeouts(t *ServerTimeouts) []string {
+	var problems []string
+	fields := []struct {
+		name  string
+		value int
+	}{
+		{"read_header_seconds", t.ReadHeaderSeconds},
+		{"read_seconds", t.ReadSeconds},
+		{"write_seconds", t.WriteSeconds},
+		{"idle_seconds", t.IdleSeconds},
+	}
+	for _, f := range fields {
+		if f.value < 0 {
+			problems = append(problems, "server.timeouts."+f.name+": must not be negative")
+		}
+	}
+	return problems
+}
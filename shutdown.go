@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//
+// ===================== GRACEFUL SHUTDOWN =====================
+//
+
+const defaultShutdownGrace = 15 * time.Second
+
+// serveGracefully runs serve until it fails or SIGINT/SIGTERM arrives. On a
+// signal the listener stops accepting, in-flight requests get the grace
+// period to finish, and then their contexts are cancelled so long reads bail
+// out. The audit log is closed on the way out so no entry is half-written.
+func serveGracefully(srv *http.Server, grace time.Duration, serve func() error) error {
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv.BaseContext = func(net.Listener) context.Context { return baseCtx }
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	done := make(chan error, 1)
+	go func() {
+		sig, ok := <-sigs
+		if !ok {
+			return
+		}
+		slog.Info("shutting down", "signal", sig.String(), "grace", grace.String())
+		sdNotify("STOPPING=1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			// Grace period over: abort whatever is still running.
+			cancelRequests()
+			err = srv.Close()
+		}
+		done <- err
+	}()
+
+	sdNotify("READY=1")
+	startWatchdog(baseCtx)
+
+	err := serve()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = <-done
+	}
+	auditLog.close()
+	return err
+}
+
+func (s *ServerConfig) shutdownGrace() time.Duration {
+	if s == nil || s.ShutdownGraceSeconds <= 0 {
+		return defaultShutdownGrace
+	}
+	return time.Duration(s.ShutdownGraceSeconds) * time.Second
+}
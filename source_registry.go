@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//
+// ===================== SOURCE TYPES =====================
+//
+
+// Every source can be read (LogSource). What else it can do is told by the
+// optional capabilities it implements, so callers check for the capability
+// rather than for a type:
+//
+//   - rangeLogSource answers since/until reads
+//   - tailableLogSource follows new lines as they are written
+//   - pollableLogSource tells cheaply whether its content changed
+//   - localLogSource is backed by a file on this host, which the volume and
+//     disk monitors, clock inference and preflight stat directly
+
+// tailableLogSource is implemented by sources that can follow new lines.
+type tailableLogSource interface {
+	// Follow hands every newly written chunk of lines to emit until ctx
+	// is cancelled.
+	Follow(ctx context.Context, emit func(text string)) error
+}
+
+// pollableLogSource is implemented by sources that can tell whether their
+// content changed without reading it.
+type pollableLogSource interface {
+	// ChangeToken returns a value that differs whenever the content may
+	// have changed.
+	ChangeToken() (string, error)
+}
+
+// localLogSource is implemented by sources that read a file on the agent's
+// host.
+type localLogSource interface {
+	// LocalPath returns the path of the file read.
+	LocalPath() string
+}
+
+// targetLocalPath returns the local file a target reads, if its source is
+// backed by one.
+func targetLocalPath(target LogTarget) (string, bool) {
+	st, ok := lookupSourceType(target.Type)
+	if !ok {
+		return "", false
+	}
+	src, err := st.create(nil, "", "", target)
+	if err != nil {
+		return "", false
+	}
+	local, ok := src.(localLogSource)
+	if !ok {
+		return "", false
+	}
+	return local.LocalPath(), true
+}
+
+// sourceType builds and checks the targets of one type: value.
+type sourceType struct {
+	name string
+	// create builds the source of a valid target. cfg is nil when only the
+	// source's capabilities are asked for.
+	create func(cfg *Config, app, key string, target LogTarget) (LogSource, error)
+	// validate checks the type-specific fields of a target.
+	validate func(field string, target LogTarget) (problems, warnings []string)
+}
+
+// registeredSourceTypes are the known target types, in registration order.
+var registeredSourceTypes []sourceType
+
+// registerSourceType adds a target type. Call it from an init function in
+// the file defining the source.
+func registerSourceType(t sourceType) {
+	registeredSourceTypes = append(registeredSourceTypes, t)
+}
+
+func lookupSourceType(name string) (sourceType, bool) {
+	for _, t := range registeredSourceTypes {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return sourceType{}, false
+}
+
+// sourceTypeList names the registered types for error messages, e.g.
+// "file or api".
+func sourceTypeList() string {
+	names := make([]string, len(registeredSourceTypes))
+	for i, t := range registeredSourceTypes {
+		names[i] = t.name
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func init() {
+	registerSourceType(sourceType{name: "file", create: newFileTargetSource, validate: validateFileTarget})
+	registerSourceType(sourceType{name: "api", create: newAPITargetSource, validate: validateAPITarget})
+}
+
+func newFileTargetSource(cfg *Config, app, key string, target LogTarget) (LogSource, error) {
+	if target.Path == "" {
+		return nil, fmt.Errorf("log %q for app %q: missing path", key, app)
+	}
+	return cfg.readSettings(app, key).fileSource(target.Path), nil
+}
+
+func validateFileTarget(field string, target LogTarget) (problems, warnings []string) {
+	if target.Path == "" {
+		return []string{field + ": missing path (required for type file)"}, nil
+	}
+	if target.URL != "" || target.HTTP != nil || target.Response != nil {
+		problems = append(problems, field+": url, http and response are not used by type file; remove them or change the type to api")
+	}
+	if _, err := os.Stat(target.Path); err != nil {
+		warnings = append(warnings, fmt.Sprintf("%s: %v", field, err))
+	}
+	return problems, warnings
+}
+
+func newAPITargetSource(_ *Config, app, key string, target LogTarget) (LogSource, error) {
+	if target.URL == "" {
+		return nil, fmt.Errorf("log %q for app %q: missing url", key, app)
+	}
+	client, err := target.HTTP.client()
+	if err != nil {
+		return nil, fmt.Errorf("log %q for app %q: %w", key, app, err)
+	}
+	return &APILogSource{
+		URL:      target.URL,
+		Client:   client,
+		Headers:  target.HTTP.requestHeaders(),
+		Retry:    target.HTTP.retryPolicy(),
+		Response: target.Response,
+	}, nil
+}
+
+func validateAPITarget(field string, target LogTarget) (problems, warnings []string) {
+	if target.URL == "" {
+		return []string{field + ": missing url (required for type api)"}, nil
+	}
+	if target.Path != "" {
+		problems = append(problems, field+": path is not used by type api; remove it or change the type to file")
+	}
+	if err := checkHTTPURL(target.URL); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.url: %v", field, err))
+	}
+	if target.HTTP != nil {
+		problems = append(problems, validateHTTPClientConfig(field+".http", target.HTTP)...)
+	}
+	if target.Response != nil {
+		problems = append(problems, validateResponseConfig(field+".response", target.Response)...)
+	}
+	return problems, nil
+}
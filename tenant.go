@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// ===================== TENANTS =====================
+//
+
+// tenantHeader selects a tenant for callers whose key does not already
+// belong to one (operators, or an agent running without auth).
+const tenantHeader = "X-Tenant"
+
+// TenantConfig scopes a team to a subset of the configured apps. Callers
+// authenticated with one of the tenant's keys are always confined to it;
+// other callers opt in with the X-Tenant header.
+type TenantConfig struct {
+	// Apps lists the app names the tenant may read. App names stay global,
+	// so two tenants share an app only by both listing it.
+	Apps  []string       `yaml:"apps"`
+	Keys  []APIKeyConfig `yaml:"keys,omitempty"`
+	Quota *TenantQuota   `yaml:"quota,omitempty"`
+}
+
+// TenantQuota is a token bucket shared by every caller of the tenant, on
+// top of the per-client server.rate_limit.
+type TenantQuota struct {
+	RPS   float64 `yaml:"rps,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+}
+
+type tenantKey struct{}
+
+func tenantFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(tenantKey{}).(string)
+	return name
+}
+
+// appVisible reports whether the caller's tenant, if any, may see app.
+func appVisible(ctx context.Context, cfg *Config, app string) bool {
+	name := tenantFromContext(ctx)
+	if name == "" {
+		return true
+	}
+	return slices.Contains(cfg.Tenants[name].Apps, app)
+}
+
+func (c *Config) hasTenantKeys() bool {
+	for _, t := range c.Tenants {
+		if len(t.Keys) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTenantKey finds the tenant owning token. Tenants are checked in
+// name order so a duplicated key resolves the same way every time.
+func matchTenantKey(tenants map[string]TenantConfig, token string) (string, APIKeyConfig, bool) {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if key, ok := matchAPIKey(tenants[name].Keys, token); ok {
+			return name, key, true
+		}
+	}
+	return "", APIKeyConfig{}, false
+}
+
+// tenantMiddleware resolves the caller's tenant, applies its quota and keeps
+// it away from routes that reach beyond its apps. It runs inside auth so a
+// tenant key can pin the tenant.
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := getConfig()
+		if cfg == nil || len(cfg.Tenants) == 0 || r.URL.Path == "/health" || isUIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name := r.Header.Get(tenantHeader)
+		if p, ok := principalFromContext(r.Context()); ok && p.Tenant != "" {
+			if name != "" && name != p.Tenant {
+				writeError(w, r, http.StatusForbidden, fmt.Sprintf("%q belongs to tenant %q", p.Name, p.Tenant))
+				return
+			}
+			name = p.Tenant
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, ok := cfg.Tenants[name]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("unknown tenant %q", name))
+			return
+		}
+		if !tenantRouteAllowed(r) {
+			writeError(w, r, http.StatusForbidden, fmt.Sprintf("%s is not available to tenant callers", r.URL.Path))
+			return
+		}
+		if q := tenant.Quota; q != nil && q.RPS > 0 {
+			if ok, wait := rateLimiter.allow("tenant:"+name, q.RPS, q.Burst, time.Now()); !ok {
+				tooManyRequests(w, r, wait, fmt.Sprintf("quota of tenant %q exceeded", name))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, name)))
+	})
+}
+
+// tenantRouteAllowed keeps tenant callers on the log routes and read-only
+// views of their own apps; admin, debug and config writes affect everyone,
+// and actions such as /logs/apply-patch change the host itself.
+func tenantRouteAllowed(r *http.Request) bool {
+	if isActionRequest(r) {
+		return false
+	}
+	switch path := r.URL.Path; {
+	case path == "/logs", strings.HasPrefix(path, "/logs/"):
+		return true
+	case path == "/version", path == "/openapi.json", strings.HasPrefix(path, "/schemas/"):
+		return true
+	case path == "/config/apps", strings.HasPrefix(path, "/config/apps/"):
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	}
+	return false
+}
+
+func validateTenants(cfg *Config, seenKeys map[string]bool) (problems, warnings []string) {
+	names := make([]string, 0, len(cfg.Tenants))
+	for name := range cfg.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := cfg.Tenants[name]
+		field := "tenants." + name
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, "tenants: tenant name must not be empty")
+			continue
+		}
+		if len(t.Apps) == 0 {
+			warnings = append(warnings, field+".apps: no apps; the tenant can read nothing")
+		}
+		for _, app := range t.Apps {
+			if _, ok := cfg.Apps[app]; !ok {
+				warnings = append(warnings, fmt.Sprintf("%s.apps: unknown app %q", field, app))
+			}
+		}
+		problems = append(problems, validateAPIKeys(field+".keys", t.Keys, seenKeys)...)
+		if q := t.Quota; q != nil {
+			if q.RPS < 0 {
+				problems = append(problems, field+".quota.rps: must not be negative")
+			}
+			if q.Burst < 0 {
+				problems = append(problems, field+".quota.burst: must not be negative")
+			}
+		}
+	}
+	return problems, warnings
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// ===================== TIME PARAMETERS =====================
+//
+
+// parseTimeParam reads a time given to any endpoint, so since=, until= and
+// search terms all accept the same forms:
+//
+//   - an RFC 3339 time: 2024-05-01T12:00:00Z
+//   - a Unix time in seconds, with an optional fraction, or in
+//     milliseconds when it has 13 or more digits: 1714564800
+//   - now, optionally moved by a duration: now, now-1h, now+5m
+//   - a duration back from now, with or without the minus: -15m, 15m
+//
+// Durations are Go durations, plus whole days such as 7d.
+func parseTimeParam(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, ok := parseUnixTime(v); ok {
+		return t, nil
+	}
+	if rest, ok := strings.CutPrefix(v, "now"); ok {
+		if rest == "" {
+			return now, nil
+		}
+		d, err := parseTimeOffset(rest[1:])
+		if err != nil || (rest[0] != '-' && rest[0] != '+') {
+			return time.Time{}, fmt.Errorf("expected now, now-<duration> or now+<duration>, got %q", v)
+		}
+		if rest[0] == '-' {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+	d, err := parseTimeOffset(strings.TrimPrefix(v, "-"))
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("expected an RFC 3339 time, a Unix time, now-<duration> or a duration such as -15m, got %q", v)
+	}
+	return now.Add(-d), nil
+}
+
+// parseUnixTime reads seconds since the epoch, or milliseconds for values
+// of 13 digits or more.
+func parseUnixTime(v string) (time.Time, bool) {
+	whole, frac, _ := strings.Cut(v, ".")
+	if whole == "" || strings.TrimLeft(whole, "0123456789") != "" {
+		return time.Time{}, false
+	}
+	if len(whole) >= 13 && frac == "" {
+		ms, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.UnixMilli(ms).UTC(), true
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(secs)
+	return time.Unix(sec, int64((secs-float64(sec))*1e9)).UTC(), true
+}
+
+// parseTimeOffset is time.ParseDuration plus whole days.
+func parseTimeOffset(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid day count %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+//
+// ===================== TLS =====================
+//
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile enables mTLS: client certificates are verified against
+	// this bundle when presented.
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+	// RequireClientCert rejects connections without a valid client cert.
+	RequireClientCert bool `yaml:"require_client_cert,omitempty"`
+}
+
+// buildTLSConfig loads the server certificate and optional client CA bundle.
+// Certificates are read once at startup; rotating them needs a restart.
+func buildTLSConfig(c *TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA: %w", err)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsCfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+func validateTLSConfig(c *TLSConfig) []string {
+	var problems []string
+	if c.CertFile == "" || c.KeyFile == "" {
+		problems = append(problems, "server.tls: cert_file and key_file are both required")
+	}
+	if c.RequireClientCert && c.ClientCAFile == "" {
+		problems = append(problems, "server.tls.require_client_cert: needs client_ca_file to verify clients against")
+	}
+	for _, f := range []struct{ field, path string }{
+		{"cert_file", c.CertFile},
+		{"key_file", c.KeyFile},
+		{"client_ca_file", c.ClientCAFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			problems = append(problems, fmt.Sprintf("server.tls.%s: %v", f.field, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//
+// ===================== DASHBOARD =====================
+//
+
+//go:embed ui
+var uiFiles embed.FS
+
+// registerUIHandlers serves the embedded dashboard at / and its assets under
+// /ui/. The pages hold no data; everything is fetched from the API with the
+// key the user enters, so they are exempt from auth.
+func registerUIHandlers(mux *http.ServeMux) {
+	assets, _ := fs.Sub(uiFiles, "ui")
+	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServerFS(assets)))
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, assets, "index.html")
+	})
+}
+
+func isUIPath(path string) bool {
+	return path == "/" || strings.HasPrefix(path, "/ui/")
+}
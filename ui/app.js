@@ -0,0 +1,161 @@
+"use strict";
+
+// The dashboard only uses the agent's public HTTP API, so everything it
+// shows is also reachable with curl.
+
+const state = { app: "", log: "", entries: [], timer: null };
+const $ = (id) => document.getElementById(id);
+
+function headers() {
+  const key = sessionStorage.getItem("apiKey");
+  return key ? { "X-API-Key": key } : {};
+}
+
+async function api(path, options = {}) {
+  const resp = await fetch(path, {
+    ...options,
+    headers: { ...headers(), ...(options.headers || {}) },
+  });
+  const text = await resp.text();
+  if (!resp.ok) {
+    let message = text.trim();
+    try {
+      message = JSON.parse(text).message || message;
+    } catch (_) {
+      // not an error envelope; show the raw body
+    }
+    throw new Error(`${resp.status}: ${message}`);
+  }
+  return text ? JSON.parse(text) : null;
+}
+
+function setStatus(msg, isError = false) {
+  $("status").textContent = msg;
+  $("status").className = isError ? "error" : "";
+}
+
+async function loadTargets() {
+  const list = $("targets");
+  list.replaceChildren();
+  let data;
+  try {
+    data = await api("/config/apps");
+  } catch (err) {
+    setStatus(`Could not list apps (${err.message})`, true);
+    return;
+  }
+
+  for (const name of data.names) {
+    const item = document.createElement("li");
+    const title = document.createElement("span");
+    title.textContent = name;
+    item.append(title);
+
+    for (const key of Object.keys(data.apps[name].logs || {}).sort()) {
+      const target = data.apps[name].logs[key];
+      const button = document.createElement("button");
+      button.type = "button";
+      button.textContent = `${key} (${target.type})`;
+      button.addEventListener("click", () => selectTarget(name, key, button));
+      item.append(button);
+    }
+    list.append(item);
+  }
+}
+
+function selectTarget(app, log, button) {
+  document.querySelectorAll("nav button.active").forEach((b) => b.classList.remove("active"));
+  button.classList.add("active");
+  state.app = app;
+  state.log = log;
+  $("current").textContent = `${app} / ${log}`;
+  $("recommendations").replaceChildren();
+  refresh();
+}
+
+async function refresh() {
+  if (!state.app) {
+    return;
+  }
+  const params = new URLSearchParams({ app: state.app, log: state.log, lines: $("lines").value });
+  try {
+    const data = await api(`/logs?${params}`);
+    state.entries = Array.isArray(data) ? data : [];
+    render(data);
+    setStatus(`Updated ${new Date().toLocaleTimeString()}`);
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+}
+
+function render(data) {
+  const out = $("logs");
+  out.replaceChildren();
+  if (!Array.isArray(data)) {
+    out.textContent = JSON.stringify(data, null, 2);
+    return;
+  }
+
+  const severity = $("severity").value;
+  for (const entry of data) {
+    if (severity && entry.severity !== severity) {
+      continue;
+    }
+    const line = document.createElement("div");
+    line.textContent = entry.raw;
+    if (entry.severity) {
+      line.className = entry.severity;
+    }
+    out.append(line);
+  }
+  out.scrollTop = out.scrollHeight;
+}
+
+async function analyze() {
+  if (!state.entries.length) {
+    setStatus("Nothing to analyze; load a target first.", true);
+    return;
+  }
+  setStatus("Analyzing...");
+  try {
+    const data = await api("/logs/analyze", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ logs: state.entries }),
+    });
+    const box = $("recommendations");
+    box.replaceChildren();
+    for (const rec of data.recommendations || []) {
+      const div = document.createElement("div");
+      div.className = "rec";
+      const title = document.createElement("strong");
+      title.textContent = `[${rec.severity}] ${rec.title}`;
+      div.append(title, document.createElement("br"), rec.description);
+      box.append(div);
+    }
+    setStatus("Analysis complete");
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+}
+
+function setLive(on) {
+  clearInterval(state.timer);
+  state.timer = on ? setInterval(refresh, 5000) : null;
+}
+
+$("auth").addEventListener("submit", (event) => {
+  event.preventDefault();
+  sessionStorage.setItem("apiKey", $("api-key").value);
+  $("api-key").value = "";
+  loadTargets();
+});
+$("refresh").addEventListener("click", refresh);
+$("analyze").addEventListener("click", analyze);
+$("severity").addEventListener("change", () => render(state.entries));
+$("live").addEventListener("change", (event) => setLive(event.target.checked));
+
+api("/version")
+  .then((v) => { $("version").textContent = v.version; })
+  .catch(() => {});
+loadTargets();
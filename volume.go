@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// ===================== VOLUME ANOMALIES =====================
+//
+
+// VolumeConfig samples how fast file targets grow and flags a target that
+// normally logs constantly going silent, or one suddenly writing many
+// times its usual volume. Findings show up at /logs/volume, in the agent's
+// own log and among the /logs/analyze recommendations.
+type VolumeConfig struct {
+	// IntervalSeconds is the sampling period (default 60).
+	IntervalSeconds int `yaml:"interval_seconds,omitempty"`
+	// BurstFactor flags an interval with this many times the baseline
+	// volume (default 10).
+	BurstFactor float64 `yaml:"burst_factor,omitempty"`
+	// SilenceIntervals flags this many empty intervals in a row from a
+	// target that wrote something in nearly every interval before
+	// (default 5).
+	SilenceIntervals int `yaml:"silence_intervals,omitempty"`
+	// WarmupIntervals is how many samples build the baseline before a
+	// target can be flagged (default 10).
+	WarmupIntervals int `yaml:"warmup_intervals,omitempty"`
+	// StateFile keeps the learned baselines across restarts. It is
+	// written after every sample and read when monitoring starts.
+	StateFile string `yaml:"state_file,omitempty"`
+}
+
+func (v *VolumeConfig) interval() time.Duration {
+	if v.IntervalSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(v.IntervalSeconds) * time.Second
+}
+
+func (v *VolumeConfig) burstFactor() float64 {
+	if v.BurstFactor <= 0 {
+		return 10
+	}
+	return v.BurstFactor
+}
+
+func (v *VolumeConfig) silenceIntervals() int {
+	if v.SilenceIntervals <= 0 {
+		return 5
+	}
+	return v.SilenceIntervals
+}
+
+func (v *VolumeConfig) warmupIntervals() int {
+	if v.WarmupIntervals <= 0 {
+		return 10
+	}
+	return v.WarmupIntervals
+}
+
+const (
+	// volumeAlpha weighs each new interval in the baselines.
+	volumeAlpha = 0.1
+	// volumeBurstFloor keeps targets that barely log from being flagged
+	// for a few hundred bytes.
+	volumeBurstFloor = 1 << 10
+	// volumeActiveRatio is the share of non-empty intervals a target
+	// needs before an empty streak counts as silence.
+	volumeActiveRatio = 0.9
+)
+
+const (
+	volumeWarmingUp = "warming_up"
+	volumeOK        = "ok"
+	volumeSilent    = "silent"
+	volumeBurst     = "burst"
+)
+
+// VolumeStats is what /logs/volume reports for one file target.
+type VolumeStats struct {
+	App     string `json:"app"`
+	Log     string `json:"log"`
+	Service string `json:"service,omitempty"`
+	// State is warming_up, ok, silent or burst.
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// LastBytes is what the target wrote in the last interval and
+	// BaselineBytes its moving average.
+	LastBytes     int64   `json:"last_bytes"`
+	BaselineBytes float64 `json:"baseline_bytes"`
+	Samples       int     `json:"samples"`
+
+	size         int64
+	sized        bool
+	active       float64
+	activeBefore float64
+	emptyStreak  int
+}
+
+var (
+	volumeMu    sync.Mutex
+	volumeStats = map[fanOutTarget]*VolumeStats{}
+)
+
+// startVolumeMonitor samples file target sizes while a volume section is
+// configured.
+func startVolumeMonitor() {
+	go func() {
+		var loaded string
+		for {
+			cfg := getConfig()
+			if cfg == nil || cfg.Volume == nil {
+				time.Sleep(time.Minute)
+				continue
+			}
+			if path := cfg.Volume.StateFile; path != "" && path != loaded {
+				loaded = path
+				loadBaselines(cfg, path, time.Now())
+			}
+			sampleVolume(cfg, time.Now())
+			saveBaselines(cfg)
+			time.Sleep(cfg.Volume.interval())
+		}
+	}()
+}
+
+func sampleVolume(cfg *Config, now time.Time) {
+	volumeMu.Lock()
+	defer volumeMu.Unlock()
+
+	seen := map[fanOutTarget]bool{}
+	for _, t := range fanOutTargets(cfg, fanOutWildcard, fanOutWildcard) {
+		target := cfg.Apps[t.app].Logs[t.key]
+		path, ok := targetLocalPath(target)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seen[t] = true
+
+		s, ok := volumeStats[t]
+		if !ok {
+			volumeStats[t] = &VolumeStats{App: t.app, Log: t.key, Service: target.Service, State: volumeWarmingUp, Since: now, size: info.Size(), sized: true}
+			continue
+		}
+		s.Service = target.Service
+		if !s.sized {
+			// A baseline loaded or seeded without a size to diff against.
+			s.size, s.sized = info.Size(), true
+			continue
+		}
+		written := info.Size() - s.size
+		if written < 0 {
+			// Truncated or rotated: everything in the new file is new.
+			written = info.Size()
+		}
+		s.size = info.Size()
+		s.observe(cfg.Volume, written, now)
+	}
+	for t := range volumeStats {
+		if !seen[t] {
+			delete(volumeStats, t)
+		}
+	}
+}
+
+// observe adds one interval's volume and updates the state.
+func (s *VolumeStats) observe(v *VolumeConfig, written int64, now time.Time) {
+	isActive := 0.0
+	if written > 0 {
+		isActive = 1
+		s.emptyStreak = 0
+	} else {
+		if s.emptyStreak == 0 {
+			s.activeBefore = s.active
+		}
+		s.emptyStreak++
+	}
+
+	state := volumeOK
+	switch {
+	case s.Samples < v.warmupIntervals():
+		state = volumeWarmingUp
+	case s.emptyStreak >= v.silenceIntervals() && s.activeBefore >= volumeActiveRatio:
+		state = volumeSilent
+	case float64(written) > v.burstFactor()*max(s.BaselineBytes, volumeBurstFloor):
+		state = volumeBurst
+	}
+
+	if s.Samples == 0 {
+		s.BaselineBytes, s.active = float64(written), isActive
+	} else {
+		s.BaselineBytes += volumeAlpha * (float64(written) - s.BaselineBytes)
+		s.active += volumeAlpha * (isActive - s.active)
+	}
+	s.Samples++
+	s.LastBytes = written
+
+	if state == s.State {
+		return
+	}
+	switch state {
+	case volumeSilent:
+		slog.Warn("log target went silent", "app", s.App, "log", s.Log, "empty_intervals", s.emptyStreak)
+	case volumeBurst:
+		slog.Warn("log volume burst", "app", s.App, "log", s.Log, "bytes", written, "baseline_bytes", int64(s.BaselineBytes))
+	case volumeOK:
+		if s.State == volumeSilent || s.State == volumeBurst {
+			slog.Info("log volume back to normal", "app", s.App, "log", s.Log)
+		}
+	}
+	s.State, s.Since = state, now
+}
+
+// volumeSnapshot copies the stats of the targets the caller may see.
+func volumeSnapshot(r *http.Request, cfg *Config, app, key string) []VolumeStats {
+	volumeMu.Lock()
+	out := make([]VolumeStats, 0, len(volumeStats))
+	for t, s := range volumeStats {
+		if (app != "" && t.app != app) || (key != "" && t.key != key) {
+			continue
+		}
+		if cfg != nil && !appVisible(r.Context(), cfg, t.app) {
+			continue
+		}
+		out = append(out, *s)
+	}
+	volumeMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].App != out[j].App {
+			return out[i].App < out[j].App
+		}
+		return out[i].Log < out[j].Log
+	})
+	return out
+}
+
+// volumeHandler lists volume stats for every sampled file target, or for
+// one with app= and log=.
+func volumeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	cfg := getConfig()
+	if cfg == nil || cfg.Volume == nil {
+		writeError(w, r, http.StatusNotFound, "volume monitoring is not configured")
+		return
+	}
+	q := r.URL.Query()
+	writeJSON(w, http.StatusOK, volumeSnapshot(r, cfg, q.Get("app"), q.Get("log")))
+}
+
+// volumeRecommendations turns current silence and burst findings into
+// /logs/analyze recommendations.
+func volumeRecommendations(r *http.Request, cfg *Config) []map[string]string {
+	if cfg == nil || cfg.Volume == nil {
+		return nil
+	}
+	interval := cfg.Volume.interval()
+
+	var recs []map[string]string
+	for _, s := range volumeSnapshot(r, cfg, "", "") {
+		switch s.State {
+		case volumeSilent:
+			recs = append(recs, map[string]string{
+				"title":       fmt.Sprintf("%s/%s went silent", s.App, s.Log),
+				"description": fmt.Sprintf("Nothing written for the last %d intervals of %s; it usually writes about %.0f bytes per interval. Check that the service is running and still logging there.", s.emptyStreak, interval, s.BaselineBytes),
+				"severity":    "HIGH",
+			})
+		case volumeBurst:
+			recs = append(recs, map[string]string{
+				"title":       fmt.Sprintf("Log volume burst in %s/%s", s.App, s.Log),
+				"description": fmt.Sprintf("%d bytes written in the last %s against a baseline of about %.0f; look for an error loop or a debug level left on.", s.LastBytes, interval, s.BaselineBytes),
+				"severity":    "MEDIUM",
+			})
+		}
+	}
+	return recs
+}
+
+func validateVolumeConfig(v *VolumeConfig) []string {
+	var problems []string
+	if v.IntervalSeconds < 0 {
+		problems = append(problems, "volume.interval_seconds: must not be negative")
+	}
+	if v.BurstFactor != 0 && v.BurstFactor <= 1 {
+		problems = append(problems, "volume.burst_factor: must be greater than 1")
+	}
+	if v.SilenceIntervals < 0 {
+		problems = append(problems, "volume.silence_intervals: must not be negative")
+	}
+	if v.WarmupIntervals < 0 {
+		problems = append(problems, "volume.warmup_intervals: must not be negative")
+	}
+	if v.StateFile != "" {
+		if info, err := os.Stat(filepath.Dir(v.StateFile)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("volume.state_file: directory %s does not exist", filepath.Dir(v.StateFile)))
+		}
+	}
+	return problems
+}